@@ -5,6 +5,8 @@
 package markdown
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"strings"
 	"testing"
@@ -218,3 +220,139 @@ func BenchmarkDeepList(b *testing.B) {
 func BenchmarkList(b *testing.B) {
 	bench(b, repf(func(x int) string { return "* a\n" }, 1000))
 }
+
+// smallDocs returns many small parsed documents,
+// as a server rendering comments might see.
+func smallDocs() []*Document {
+	p := Parser{Footnote: true}
+	var docs []*Document
+	for i := 0; i < 100; i++ {
+		docs = append(docs, p.Parse(fmt.Sprintf("# Comment %d\n\nSome *text* with a [link](/%d) and a note.[^n]\n\n- one\n- two\n\n[^n]: Note %d.\n", i, i, i)))
+	}
+	return docs
+}
+
+func BenchmarkToHTMLSmall(b *testing.B) {
+	docs := smallDocs()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, doc := range docs {
+			_ = ToHTML(doc)
+		}
+	}
+}
+
+func BenchmarkRendererSmall(b *testing.B) {
+	docs := smallDocs()
+	var r Renderer
+	var out bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, doc := range docs {
+			out.Reset()
+			if err := r.RenderHTML(doc, &out); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestMaxLinkParenDepth(t *testing.T) {
+	nest := func(n int) string {
+		return "[a](/" + rep("(", n) + "x" + rep(")", n) + ")\n"
+	}
+	tests := []struct {
+		max  int
+		n    int
+		link bool
+	}{
+		{0, 32, true},
+		{0, 33, false},
+		{40, 40, true},
+		{40, 41, false},
+		{1, 1, true},
+		{1, 2, false},
+	}
+	for _, tt := range tests {
+		p := Parser{MaxLinkParenDepth: tt.max}
+		out := ToHTML(p.Parse(nest(tt.n)))
+		if link := strings.Contains(out, "<a "); link != tt.link {
+			t.Errorf("MaxLinkParenDepth=%d, depth %d: ToHTML = %q, want link=%v", tt.max, tt.n, out, tt.link)
+		}
+	}
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	// The tables case expands a small input into a huge table.
+	in := rep("abc\ndef\n|-\n", 30000)
+	p := Parser{Table: true}
+	doc := p.Parse(in)
+	full := ToHTML(doc)
+
+	pr := Printer{MaxOutputBytes: 100000}
+	html, err := pr.TryToHTML(doc)
+	if err != ErrOutputTooLarge || html != "" {
+		t.Errorf("TryToHTML with limit %d (output %d bytes) = %q, %v, want \"\", ErrOutputTooLarge", pr.MaxOutputBytes, len(full), compress(html), err)
+	}
+	if html := pr.ToHTML(doc); html != "" {
+		t.Errorf("ToHTML with limit = %q, want \"\"", compress(html))
+	}
+	if html := pr.ToHTMLDocument(doc, nil); html != "" {
+		t.Errorf("ToHTMLDocument with limit = %q, want \"\"", compress(html))
+	}
+	md, err := pr.TryFormat(doc)
+	if err != ErrOutputTooLarge || md != "" {
+		t.Errorf("TryFormat with limit = %q, %v, want \"\", ErrOutputTooLarge", compress(md), err)
+	}
+
+	pr.MaxOutputBytes = len(full)
+	html, err = pr.TryToHTML(doc)
+	if err != nil || html != full {
+		t.Errorf("TryToHTML with limit %d = %q, %v, want full output", pr.MaxOutputBytes, compress(html), err)
+	}
+}
+
+func TestParseContext(t *testing.T) {
+	in := strings.Repeat("para *text*\n\n", 10000)
+	var p Parser
+	doc, err := p.ParseContext(context.Background(), in)
+	if err != nil || len(doc.Blocks) != 10000 {
+		t.Fatalf("ParseContext(Background) = %d blocks, %v, want 10000, nil", len(doc.Blocks), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	doc, err = p.ParseContext(ctx, in)
+	if err != context.Canceled || doc != nil {
+		t.Fatalf("ParseContext(canceled) = %v, %v, want nil, context.Canceled", doc, err)
+	}
+
+	// A single short line is never checked during line processing,
+	// but the inline loop still notices the cancellation.
+	doc, err = p.ParseContext(ctx, "x\n")
+	if err != context.Canceled || doc != nil {
+		t.Fatalf("ParseContext(canceled, short) = %v, %v, want nil, context.Canceled", doc, err)
+	}
+
+	// A single huge paragraph is checked during inline parsing too.
+	// The countdown lets the check before the paragraph pass,
+	// so only a check inside the inline parser can stop it.
+	doc, err = p.ParseContext(&countdownContext{context.Background(), 1}, rep("a *b* ", 100000)+"\n")
+	if err != context.Canceled || doc != nil {
+		t.Fatalf("ParseContext(canceled, one paragraph) = %v, %v, want nil, context.Canceled", doc, err)
+	}
+}
+
+// A countdownContext is a context whose Err method
+// reports context.Canceled after it has been called n times.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
@@ -10,12 +10,17 @@ package markdown
 // [thematic break]: https://spec.commonmark.org/0.31.2/#thematic-breaks
 type ThematicBreak struct {
 	Position
+
+	// Raw is the break as written, without surrounding spaces,
+	// such as "---" or "* * *".
+	Raw string
 }
 
 func (*ThematicBreak) Block() {}
 
 func (b *ThematicBreak) printHTML(p *printer) {
-	p.html("<hr />\n")
+	p.indentHTML()
+	p.html("<hr", p.class("hr"), " />\n")
 }
 
 func (b *ThematicBreak) printMarkdown(p *printer) {
@@ -25,10 +30,11 @@ func (b *ThematicBreak) printMarkdown(p *printer) {
 
 // startThematicBreak is a [starter] for a [ThematicBreak].
 func startThematicBreak(p *parser, s line) (line, bool) {
+	raw := trimSpaceTab(s.string())
 	if !trimThematicBreak(&s) {
 		return s, false
 	}
-	p.doneBlock(&ThematicBreak{Position{p.lineno, p.lineno}})
+	p.doneBlock(&ThematicBreak{Position{p.lineno, p.lineno}, raw})
 	return line{}, true
 }
 
@@ -5,6 +5,8 @@
 package markdown
 
 import (
+	"bytes"
+	"strconv"
 	"strings"
 )
 
@@ -21,14 +23,31 @@ import (
 type CodeBlock struct {
 	Position
 	Fence string   // fence to use
-	Info  string   // info following open fence
+	Info  string   // info following open fence, trimmed and unescaped
 	Text  []string // lines of code block
+
+	// RawInfo is the info string exactly as it appeared
+	// after the opening fence, including any surrounding spaces
+	// and backslash escapes. Format prints RawInfo instead of Info
+	// when the two are consistent, so that round-tripping is exact.
+	RawInfo string
+
+	// RawIndent records, for an indented code block,
+	// the indentation of each line of Text as written in the input,
+	// such as "\t" or "  \t" or "    ". An entry is empty when the
+	// indentation cannot be reproduced exactly, as when an enclosing
+	// list item consumed part of a tab. When [Printer.MinimalDiff] is set,
+	// Format prints each line with its raw indentation, provided
+	// it still spans the four columns required at the line's new position.
+	RawIndent []string
 }
 
 func (*CodeBlock) Block() {}
 
 func (b *CodeBlock) printHTML(p *printer) {
-	p.html("<pre><code")
+	start := p.buf.Len()
+	p.indentHTML()
+	p.html("<pre", p.class("pre"), "><code")
 	if b.Info != "" {
 		// https://spec.commonmark.org/0.31.2/#info-string
 		// “The first word of the info string is typically used to
@@ -47,10 +66,21 @@ func (b *CodeBlock) printHTML(p *printer) {
 		p.html(`"`)
 	}
 	p.WriteString(">")
-	for _, s := range b.Text {
+	for i, s := range b.Text {
+		if p.CodeLineNumbers {
+			p.html(`<span class="line" data-line="`, strconv.Itoa(i+1), `">`)
+			p.text(s)
+			p.html("</span>\n")
+			continue
+		}
 		p.text(s, "\n")
 	}
 	p.html("</code></pre>\n")
+	if p.CodeBlockHTML != nil {
+		html := p.CodeBlockHTML(b, string(p.buf.Bytes()[start:]))
+		p.buf.Truncate(start)
+		p.html(html)
+	}
 }
 
 func (b *CodeBlock) printMarkdown(p *printer) {
@@ -60,7 +90,16 @@ func (b *CodeBlock) printMarkdown(p *printer) {
 			if i > 0 {
 				p.nl()
 			}
-			p.md("    ")
+			if p.TrimCodeTrailingSpace {
+				if line = trimRightSpaceTab(line); line == "" {
+					continue
+				}
+			}
+			indent := b.indent(p, i)
+			if indent == "" && line == "" {
+				continue // blank in the input too
+			}
+			p.md(indent)
 			p.md(line)
 			p.noTrim()
 		}
@@ -70,9 +109,17 @@ func (b *CodeBlock) printMarkdown(p *printer) {
 			p.maybeNL()
 		}
 		p.md(b.Fence)
-		p.md(b.Info)
+		if b.RawInfo != "" && trimSpaceTab(mdUnescaper.Replace(b.RawInfo)) == b.Info {
+			p.md(b.RawInfo)
+			p.noTrim()
+		} else {
+			p.md(b.Info)
+		}
 		for _, line := range b.Text {
 			p.nl()
+			if p.TrimCodeTrailingSpace {
+				line = trimRightSpaceTab(line)
+			}
 			p.md(line)
 			p.noTrim()
 		}
@@ -81,19 +128,78 @@ func (b *CodeBlock) printMarkdown(p *printer) {
 	}
 }
 
+// indent returns the indentation to print before line i of the
+// indented code block b: the raw indentation from the input
+// when [Printer.MinimalDiff] is set and the raw indentation
+// spans four columns at the current output position
+// (or at most four columns on a blank line, where it can be empty),
+// and four spaces otherwise.
+func (b *CodeBlock) indent(p *printer, i int) string {
+	if p.MinimalDiff && i < len(b.RawIndent) {
+		raw := b.RawIndent[i]
+		out := p.buf.Bytes()
+		start := columns(0, out[bytes.LastIndexByte(out, '\n')+1:])
+		n := columns(start, []byte(raw)) - start
+		if raw != "" && n == 4 || b.Text[i] == "" && n <= 4 {
+			return raw
+		}
+	}
+	return "    "
+}
+
+// columns returns the column reached by printing text
+// starting at column col, with tab stops every four columns.
+func columns(col int, text []byte) int {
+	for _, c := range text {
+		if c == '\t' {
+			col += 4 - col%4
+		} else {
+			col++
+		}
+	}
+	return col
+}
+
+// CodeBlocks returns the code blocks in doc, both fenced and indented,
+// in the order they appear, including those nested in
+// block quotes, lists, and details blocks.
+// The code blocks in footnotes (see [Document.Footnotes])
+// follow those in the main text.
+// For example, a documentation test could use
+// the Info and Text fields of the result to run
+// the code in every block labeled go.
+func CodeBlocks(doc *Document) []*CodeBlock {
+	var list []*CodeBlock
+	appendCodeBlocks(&list, doc.Blocks)
+	for _, note := range doc.Footnotes() {
+		appendCodeBlocks(&list, note.Blocks)
+	}
+	return list
+}
+
+// appendCodeBlocks appends to *list the code blocks in blocks.
+func appendCodeBlocks(list *[]*CodeBlock, blocks []Block) {
+	walkBlocks(blocks, func(b Block) bool {
+		if b, ok := b.(*CodeBlock); ok {
+			*list = append(*list, b)
+		}
+		return true
+	}, nil)
+}
+
 // startIndentedCodeBlock is a [starter] for an indented [CodeBlock].
 // See https://spec.commonmark.org/0.31.2/#indented-code-blocks.
 func startIndentedCodeBlock(p *parser, s line) (line, bool) {
 	// Line must start with 4 spaces and then not be blank.
 	peek := s
-	if p.para() != nil || !peek.trimSpace(4, 4, false) || peek.isBlank() {
+	if p.NoIndentedCode || p.para() != nil || !peek.trimSpace(4, 4, false) || peek.isBlank() {
 		return s, false
 	}
 
-	b := &indentBuilder{}
+	b := &indentBuilder{raw: []string{rawIndent(s, peek)}}
 	p.addBlock(b)
 	if peek.nl != '\n' {
-		p.corner = true // goldmark does not normalize to \n
+		p.noteCorner("indented code line ending not \\n") // goldmark does not normalize to \n
 	}
 	b.text = append(b.text, peek.string())
 	return line{}, true
@@ -103,7 +209,7 @@ func startIndentedCodeBlock(p *parser, s line) (line, bool) {
 // See https://spec.commonmark.org/0.31.2/#fenced-code-blocks.
 func startFencedCodeBlock(p *parser, s line) (line, bool) {
 	// Line must start with fence.
-	indent, fence, info, ok := trimFence(&s)
+	indent, fence, info, raw, ok := trimFence(p, &s)
 	if !ok {
 		return s, false
 	}
@@ -111,31 +217,32 @@ func startFencedCodeBlock(p *parser, s line) (line, bool) {
 	// Note presence of corner cases, for testing.
 	if fence[0] == '~' && info != "" {
 		// goldmark does not handle info after ~~~
-		p.corner = true
+		p.noteCorner("info string after ~~~ fence")
 	} else if info != "" && !isLetter(info[0]) {
 		// goldmark does not allow numbered info.
 		// goldmark does not treat a tab as introducing a new word.
-		p.corner = true
+		p.noteCorner("info string not starting with letter")
 	}
 	for _, c := range info {
 		if isUnicodeSpace(c) {
 			if c != ' ' {
 				// goldmark only breaks on space
-				p.corner = true
+				p.noteCorner("info string non-space separator")
 			}
 			break
 		}
 	}
 
-	p.addBlock(&fenceBuilder{indent, fence, info, nil})
+	p.addBlock(&fenceBuilder{indent, fence, info, raw, nil})
 	return line{}, true
 }
 
 // trimFence attempts to trim leading indentation (up to 3 spaces),
 // a code fence, and an info string from s.
-// If successful, it returns those values and ok=true, leaving s empty.
+// If successful, it returns those values, the info string as written (raw),
+// and ok=true, leaving s empty.
 // If unsuccessful, it leaves s unmodified and returns ok=false.
-func trimFence(s *line) (indent int, fence, info string, ok bool) {
+func trimFence(p *parser, s *line) (indent int, fence, info, raw string, ok bool) {
 	t := *s
 	indent = 0
 	for indent < 3 && t.trimSpace(1, 1, false) {
@@ -155,11 +262,12 @@ func trimFence(s *line) (indent int, fence, info string, ok bool) {
 		return
 	}
 
-	txt := mdUnescaper.Replace(t.trimString())
+	txt := p.unescape(t.trimString())
 	if c == '`' && strings.Contains(txt, "`") {
 		return
 	}
 	info = trimSpaceTab(txt)
+	raw = t.string()
 	fence = f[:n]
 	ok = true
 	*s = line{}
@@ -170,16 +278,29 @@ func trimFence(s *line) (indent int, fence, info string, ok bool) {
 type indentBuilder struct {
 	indent string
 	text   []string
+	raw    []string // raw indentation of text lines (CodeBlock.RawIndent)
+}
+
+// rawIndent returns the code block indentation trimmed from s to produce t,
+// or an empty string if it includes only part of a tab.
+func rawIndent(s, t line) string {
+	if s.spaces != 0 || t.spaces != 0 {
+		return ""
+	}
+	return s.text[s.i:t.i]
 }
 
 func (c *indentBuilder) extend(p *parser, s line) (line, bool) {
 	// Extension lines must start with 4 spaces or be blank.
-	if !s.trimSpace(4, 4, true) {
+	t := s
+	if !t.trimSpace(4, 4, true) {
 		return s, false
 	}
+	c.raw = append(c.raw, rawIndent(s, t))
+	s = t
 	c.text = append(c.text, s.string())
 	if s.nl != '\n' {
-		p.corner = true // goldmark does not normalize to \n
+		p.noteCorner("indented code line ending not \\n") // goldmark does not normalize to \n
 	}
 	return line{}, true
 }
@@ -190,7 +311,7 @@ func (b *indentBuilder) build(p *parser) Block {
 	for len(b.text) > 0 && b.text[len(b.text)-1] == "" {
 		b.text = b.text[:len(b.text)-1]
 	}
-	return &CodeBlock{p.pos(), "", "", b.text}
+	return &CodeBlock{p.pos(), "", "", b.text, "", b.raw[:len(b.text)]}
 }
 
 // A fenceBuilder is a [blockBuilder] for a fenced [CodeBlock].
@@ -198,6 +319,7 @@ type fenceBuilder struct {
 	indent int
 	fence  string
 	info   string
+	raw    string // info as written
 	text   []string
 }
 
@@ -205,21 +327,23 @@ func (c *fenceBuilder) extend(p *parser, s line) (line, bool) {
 	// Check for closing fence, which must be at least as long as opening fence, with no info.
 	// The closing fence can be indented less than the opening one.
 	peek := s
-	if _, fence, info, ok := trimFence(&peek); ok && strings.HasPrefix(fence, c.fence) && info == "" {
+	if _, fence, info, _, ok := trimFence(p, &peek); ok && strings.HasPrefix(fence, c.fence) && info == "" {
 		return line{}, false
 	}
 
 	// Otherwise trim the indentation from the fence line, if present.
 	if !s.trimSpace(c.indent, c.indent, false) {
-		p.corner = true // goldmark mishandles fenced blank lines with not enough spaces
+		p.noteCorner("fenced code line underindented") // goldmark mishandles fenced blank lines with not enough spaces
 		s.trimSpace(0, c.indent, false)
 	}
 
 	c.text = append(c.text, s.string())
-	p.corner = p.corner || s.nl != '\n' // goldmark does not normalize to \n
+	if s.nl != '\n' {
+		p.noteCorner("fenced code line ending not \\n") // goldmark does not normalize to \n
+	}
 	return line{}, true
 }
 
 func (c *fenceBuilder) build(p *parser) Block {
-	return &CodeBlock{p.pos(), c.fence, c.info, c.text}
+	return &CodeBlock{p.pos(), c.fence, c.info, c.text, c.raw, nil}
 }
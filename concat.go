@@ -0,0 +1,240 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package markdown
+
+import "strconv"
+
+// Concat returns a document containing the blocks of docs, in order.
+//
+// The link reference definitions of the result are the union
+// of those in docs. When more than one document defines the same label,
+// the first definition wins, as it would within a single document.
+// Links in the blocks were resolved when each document was parsed,
+// so they are unaffected by the merge.
+// The [Document.UsedLinks] of the result is the union of those in docs.
+//
+// Footnotes are matched to their references by pointer,
+// so footnotes from different documents never collide in HTML output.
+// In Markdown output, though, footnotes are written using their labels,
+// so Concat renames footnotes whose labels collide with the label of
+// a different footnote in an earlier document, appending -2, -3, and so on.
+// The [Document.Notes] of the result lists the footnotes of all docs.
+//
+// The result shares blocks with docs, except that when Concat
+// renames any footnote of a document, it copies that document's blocks
+// and footnotes and renames the copies, leaving docs unmodified.
+func Concat(docs ...*Document) *Document {
+	out := &Document{Links: make(map[string]*Link)}
+	labels := make(map[string]*Footnote) // normalized label → footnote
+	for _, doc := range docs {
+		for _, key := range doc.linkKeys() {
+			if _, ok := out.Links[key]; !ok {
+				out.Links[key] = doc.Links[key]
+				out.LinkOrder = append(out.LinkOrder, key)
+			}
+		}
+		for key := range doc.UsedLinks {
+			if out.UsedLinks == nil {
+				out.UsedLinks = make(map[string]bool)
+			}
+			out.UsedLinks[key] = true
+		}
+
+		// Rename colliding footnotes.
+		notes := doc.Footnotes()
+		renamed := make(map[*Footnote]string)
+		var keep []*Footnote
+		for _, note := range notes {
+			key := normalizeLabel(note.Label)
+			if old, ok := labels[key]; ok && old != note {
+				for n := 2; ; n++ {
+					label := note.Label + "-" + strconv.Itoa(n)
+					if _, ok := labels[normalizeLabel(label)]; !ok {
+						renamed[note] = label
+						key = normalizeLabel(label)
+						break
+					}
+				}
+			}
+			if labels[key] == nil {
+				labels[key] = note
+				keep = append(keep, note)
+			}
+		}
+		if len(renamed) == 0 {
+			out.Blocks = append(out.Blocks, doc.Blocks...)
+			out.Notes = append(out.Notes, keep...)
+			continue
+		}
+
+		// Copy the document with the renamed footnotes.
+		c := &noteCopier{notes: make(map[*Footnote]*Footnote)}
+		for _, note := range notes {
+			x := *note
+			if label, ok := renamed[note]; ok {
+				x.Label = label
+			}
+			c.notes[note] = &x
+		}
+		for _, note := range notes {
+			c.notes[note].Blocks = c.blocks(note.Blocks)
+		}
+		out.Blocks = append(out.Blocks, c.blocks(doc.Blocks)...)
+		for _, note := range keep {
+			out.Notes = append(out.Notes, c.notes[note])
+		}
+	}
+	return out
+}
+
+// A noteCopier copies blocks, replacing references to
+// the keys of notes with references to the corresponding values.
+// It copies only the blocks and inlines that can contain
+// a [FootnoteLink]; other nodes are shared with the original.
+type noteCopier struct {
+	notes map[*Footnote]*Footnote
+}
+
+// blocks returns a copy of list.
+func (c *noteCopier) blocks(list []Block) []Block {
+	if list == nil {
+		return nil
+	}
+	out := make([]Block, len(list))
+	for i, b := range list {
+		out[i] = c.block(b)
+	}
+	return out
+}
+
+// block returns a copy of b.
+func (c *noteCopier) block(b Block) Block {
+	switch b := b.(type) {
+	case *Document:
+		x := *b
+		x.Blocks = c.blocks(b.Blocks)
+		return &x
+	case *Quote:
+		x := *b
+		x.Blocks = c.blocks(b.Blocks)
+		return &x
+	case *List:
+		x := *b
+		x.Items = c.blocks(b.Items)
+		return &x
+	case *Item:
+		x := *b
+		x.Blocks = c.blocks(b.Blocks)
+		return &x
+	case *Details:
+		x := *b
+		x.Summary = c.text(b.Summary)
+		x.Blocks = c.blocks(b.Blocks)
+		return &x
+	case *Paragraph:
+		x := *b
+		x.Text = c.text(b.Text)
+		return &x
+	case *Heading:
+		x := *b
+		x.Text = c.text(b.Text)
+		return &x
+	case *Table:
+		x := *b
+		x.Header = c.texts(b.Header)
+		x.Rows = make([][]*Text, len(b.Rows))
+		for i, row := range b.Rows {
+			x.Rows[i] = c.texts(row)
+		}
+		return &x
+	case *Text:
+		return c.text(b)
+	}
+	return b
+}
+
+// texts returns a copy of list.
+func (c *noteCopier) texts(list []*Text) []*Text {
+	out := make([]*Text, len(list))
+	for i, t := range list {
+		out[i] = c.text(t)
+	}
+	return out
+}
+
+// text returns a copy of t.
+func (c *noteCopier) text(t *Text) *Text {
+	if t == nil {
+		return nil
+	}
+	x := *t
+	x.Inline = c.inlines(t.Inline)
+	return &x
+}
+
+// inlines returns a copy of list.
+func (c *noteCopier) inlines(list Inlines) Inlines {
+	if list == nil {
+		return nil
+	}
+	out := make(Inlines, len(list))
+	for i, x := range list {
+		out[i] = c.inline(x)
+	}
+	return out
+}
+
+// inline returns a copy of x.
+func (c *noteCopier) inline(x Inline) Inline {
+	switch x := x.(type) {
+	case *FootnoteLink:
+		if note := c.notes[x.Footnote]; note != nil {
+			y := &FootnoteLink{Label: x.Label, Footnote: note}
+			if note.Label != x.Footnote.Label {
+				y.Label = note.Label
+			}
+			return y
+		}
+	case *Emph:
+		y := *x
+		y.Inner = c.inlines(x.Inner)
+		return &y
+	case *Strong:
+		y := *x
+		y.Inner = c.inlines(x.Inner)
+		return &y
+	case *Del:
+		y := *x
+		y.Inner = c.inlines(x.Inner)
+		return &y
+	case *Link:
+		y := *x
+		y.Inner = c.inlines(x.Inner)
+		return &y
+	case *Image:
+		y := *x
+		y.Inner = c.inlines(x.Inner)
+		return &y
+	}
+	return x
+}
+
+// footnoteLinks appends to *list the footnote references in b,
+// including those in the footnotes they refer to.
+func footnoteLinks(b Block, list *[]*FootnoteLink) {
+	seen := make(map[*Footnote]bool)
+	var inline func(Inline) bool
+	inline = func(x Inline) bool {
+		if x, ok := x.(*FootnoteLink); ok {
+			*list = append(*list, x)
+			if x.Footnote != nil && !seen[x.Footnote] {
+				seen[x.Footnote] = true
+				walkBlocks(x.Footnote.Blocks, nil, inline)
+			}
+		}
+		return true
+	}
+	Walk(b, nil, inline)
+}
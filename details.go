@@ -0,0 +1,215 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package markdown
+
+import (
+	"strings"
+)
+
+// A Details is a [Block] representing a disclosure element,
+// rendered in HTML as <details>, with an optional <summary> line.
+// Details blocks are an extension enabled by [Parser.Details].
+//
+// In Markdown, a Details block starts with a line made up of
+// a fence of three or more colons, optional spaces or tabs,
+// the word details or spoiler, and the optional summary text.
+// The block ends at a line containing only a fence of
+// at least as many colons as the opening fence.
+// For example:
+//
+//	::: details More information
+//	Text shown when the reader expands the block.
+//	:::
+//
+// Details blocks can be nested. A closing fence closes the
+// innermost open Details block that it can close.
+// A fence line inside a fenced code block is part of the code.
+//
+// A Details block can also be written as a spoiler, like a block quote
+// but with every line starting with >! instead of >.
+// A spoiler has no summary, and its Fence is ">!".
+// For example:
+//
+//	>! Text shown when the reader
+//	>! expands the block.
+type Details struct {
+	Position
+	Fence   string  // opening and closing fence, or ">!" for a spoiler
+	Keyword string  // "details" or "spoiler"; empty for a >! spoiler
+	Summary *Text   // summary text; nil if none
+	Blocks  []Block // content of details
+}
+
+func (*Details) Block() {}
+
+func (b *Details) printHTML(p *printer) {
+	p.indentHTML()
+	p.html("<details", p.class("details"), ">\n")
+	p.depth++
+	if b.Summary != nil {
+		p.indentHTML()
+		p.html("<summary>")
+		b.Summary.printHTML(p)
+		p.html("</summary>\n")
+	}
+	for _, c := range b.Blocks {
+		c.printHTML(p)
+	}
+	p.depth--
+	p.indentHTML()
+	p.html("</details>\n")
+}
+
+func (b *Details) printMarkdown(p *printer) {
+	if b.Fence == ">!" && b.Summary == nil {
+		p.maybeQuoteNL('>')
+		p.WriteString(">! ")
+		defer p.pop(p.push(">! "))
+		printMarkdownBlocks(b.Blocks, p)
+		return
+	}
+	p.maybeNL()
+	fence := b.Fence
+	if len(fence) < 3 || strings.Trim(fence, ":") != "" {
+		fence = ":::"
+	}
+	keyword := b.Keyword
+	if keyword != "spoiler" {
+		keyword = "details"
+	}
+	p.md(fence, keyword)
+	if b.Summary != nil {
+		p.md(" ")
+		b.Summary.printMarkdown(p)
+	}
+	if len(b.Blocks) > 0 {
+		p.nl()
+		printMarkdownBlocks(b.Blocks, p)
+	}
+	p.nl()
+	p.md(fence)
+}
+
+// A detailsBuilder is a [blockBuilder] for a [Details].
+type detailsBuilder struct {
+	fence   string
+	keyword string
+	summary *Text
+}
+
+// startDetails is a [starter] for a [Details].
+func startDetails(p *parser, s line) (line, bool) {
+	if !p.Details {
+		return s, false
+	}
+	t := s
+	fence, ok := trimDetailsFence(&t)
+	if !ok {
+		return s, false
+	}
+	t.trimSpace(0, 1000, true)
+	var keyword string
+	for _, k := range []string{"details", "spoiler"} {
+		if strings.HasPrefix(t.string(), k) {
+			keyword = k
+		}
+	}
+	if keyword == "" {
+		return s, false
+	}
+	t.skip(len(keyword))
+	text := t.string()
+	if text != "" && text[0] != ' ' && text[0] != '\t' {
+		return s, false
+	}
+
+	b := &detailsBuilder{fence: fence, keyword: keyword}
+	p.addBlock(b)
+	if text = trimSpaceTab(text); text != "" {
+		b.summary = p.newText(Position{p.lineno, p.lineno}, text)
+	}
+	return line{}, true
+}
+
+// trimDetailsFence attempts to trim leading indentation (up to 3 spaces)
+// and a fence of three or more colons from s.
+// If successful, it returns the fence and ok=true.
+// If unsuccessful, it leaves s unmodified and returns ok=false.
+func trimDetailsFence(s *line) (fence string, ok bool) {
+	t := *s
+	t.trimSpace(0, 3, false)
+	f := t.string()
+	n := 0
+	for t.trim(':') {
+		n++
+	}
+	if n < 3 {
+		return "", false
+	}
+	*s = t
+	return f[:n], true
+}
+
+func (c *detailsBuilder) extend(p *parser, s line) (line, bool) {
+	// A fence inside a fenced code block is part of the code.
+	if _, ok := p.stack[len(p.stack)-1].builder.(*fenceBuilder); ok {
+		return s, true
+	}
+
+	// Check for closing fence, which must be at least as long
+	// as the opening fence and have nothing after it.
+	peek := s
+	if fence, ok := trimDetailsFence(&peek); ok && len(fence) >= len(c.fence) && peek.isBlank() {
+		// Let a nested Details close instead, if it can.
+		for _, ob := range p.stack[p.lineDepth+2:] {
+			if d, ok := ob.builder.(*detailsBuilder); ok && len(fence) >= len(d.fence) {
+				return s, true
+			}
+		}
+		return line{}, false
+	}
+	return s, true
+}
+
+func (c *detailsBuilder) build(p *parser) Block {
+	return &Details{p.pos(), c.fence, c.keyword, c.summary, p.blocks()}
+}
+
+// A spoilerBuilder is a [blockBuilder] for a >! spoiler [Details].
+type spoilerBuilder struct{}
+
+// startSpoiler is a [starter] for a >! spoiler [Details].
+// It must come before startBlockQuote in the starters list.
+func startSpoiler(p *parser, s line) (line, bool) {
+	if !p.Details {
+		return s, false
+	}
+	line, ok := trimSpoiler(s)
+	if !ok {
+		return s, false
+	}
+	p.addBlock(new(spoilerBuilder))
+	return line, true
+}
+
+// trimSpoiler attempts to trim leading indentation (up to 3 spaces),
+// the >! marker, and one optional space or tab from s.
+func trimSpoiler(s line) (line, bool) {
+	t := s
+	t.trimSpace(0, 3, false)
+	if !t.trim('>') || !t.trim('!') {
+		return s, false
+	}
+	t.trimSpace(0, 1, true)
+	return t, true
+}
+
+func (b *spoilerBuilder) extend(p *parser, s line) (line, bool) {
+	return trimSpoiler(s)
+}
+
+func (b *spoilerBuilder) build(p *parser) Block {
+	return &Details{p.pos(), ">!", "", nil, p.blocks()}
+}
@@ -4,51 +4,327 @@
 
 package markdown
 
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
 type Document struct {
 	Position
 	Blocks []Block
 	Links  map[string]*Link
+
+	// LinkOrder lists the keys of Links in the order
+	// the link reference definitions appear in the input.
+	LinkOrder []string
+
+	// UsedLinks records the keys of Links that are referred to
+	// by at least one reference link or image in the document.
+	// Text whose inline parsing was deferred (see [Parser.DeferInline])
+	// adds its references when [Text.ParseInline] is called.
+	UsedLinks map[string]bool
+
+	// Notes lists the footnote definitions (see [Parser.Footnote])
+	// in the order they appear in the input,
+	// including footnotes that are never referenced.
+	Notes []*Footnote
+
+	// NoFinalNewline records that the parsed input was not empty
+	// and did not end in a line ending.
+	// Format uses it when [Printer.FinalNewline] is FinalNewlinePreserve.
+	NoFinalNewline bool
 }
 
 func (*Document) Block() {}
 
+// LinkDefs returns the document's link reference definitions
+// in the order they appear in the input.
+// Each definition's original label is available in its [Link.Label] field.
+// Entries in b.Links missing from b.LinkOrder are listed last, sorted by key.
+func (b *Document) LinkDefs() []*Link {
+	var list []*Link
+	for _, k := range b.linkKeys() {
+		list = append(list, b.Links[k])
+	}
+	return list
+}
+
+// Footnotes returns the document's footnote definitions
+// in the order they appear in the input, as listed in b.Notes.
+// Footnotes referred to by a [FootnoteLink] in the document
+// but missing from b.Notes are listed last, in order of first reference.
+// Unlike the footnotes section of the HTML output,
+// which lists only referenced footnotes, the result includes
+// footnotes that are never referenced, so it can be used
+// to check for unused footnotes.
+func (b *Document) Footnotes() []*Footnote {
+	list := slices.Clone(b.Notes)
+	var links []*FootnoteLink
+	footnoteLinks(b, &links)
+	for _, x := range links {
+		if x.Footnote != nil && !slices.Contains(list, x.Footnote) {
+			list = append(list, x.Footnote)
+		}
+	}
+	return list
+}
+
+// linkKeys returns the keys of b.Links in the order used by [Document.LinkDefs].
+func (b *Document) linkKeys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, k := range b.LinkOrder {
+		if _, ok := b.Links[k]; ok && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	var rest []string
+	for k := range b.Links {
+		if !seen[k] {
+			rest = append(rest, k)
+		}
+	}
+	slices.Sort(rest)
+	return append(keys, rest...)
+}
+
+// Title returns the plain text of the document's first top-level heading,
+// with inline markup removed and runs of white space collapsed to single spaces.
+// It skips front matter (see [Document.Summary]).
+// If there is no heading, Title returns an empty string.
+func (b *Document) Title() string {
+	for _, c := range b.Blocks[frontMatterEnd(b.Blocks):] {
+		if h, ok := c.(*Heading); ok {
+			return plainText(h.Text)
+		}
+	}
+	return ""
+}
+
+// Summary returns the plain text of the document's first top-level paragraph,
+// with inline markup removed and runs of white space collapsed to single spaces.
+// If the text is longer than maxRunes runes, Summary truncates it,
+// preferably at a space, and appends "…", so that the result
+// is at most maxRunes runes. It does not cut emoji sequences
+// and other multi-rune characters in half. If maxRunes is zero or negative,
+// Summary does not truncate the text.
+// If there is no paragraph, Summary returns an empty string.
+//
+// Title and Summary skip YAML-style front matter: a document that
+// starts with a --- line, which this package parses as a thematic break,
+// followed by lines up to a closing --- line.
+func (b *Document) Summary(maxRunes int) string {
+	var text string
+	for _, c := range b.Blocks[frontMatterEnd(b.Blocks):] {
+		if para, ok := c.(*Paragraph); ok {
+			text = plainText(para.Text)
+			break
+		}
+	}
+	if maxRunes <= 0 || utf8.RuneCountInString(text) <= maxRunes {
+		return text
+	}
+	// Cut to at most maxRunes-1 runes, leaving room for the ellipsis,
+	// without splitting a grapheme cluster such as an emoji sequence.
+	n, i := 0, 0
+	for i < len(text) {
+		size := nextCluster(text[i:])
+		if n += utf8.RuneCountInString(text[i : i+size]); n > maxRunes-1 {
+			break
+		}
+		i += size
+	}
+	text = text[:i]
+	if i := strings.LastIndexByte(text, ' '); i > 0 {
+		text = text[:i]
+	}
+	return text + "…"
+}
+
+// plainText returns the text of t with inline markup removed
+// and runs of white space collapsed to single spaces.
+func plainText(t *Text) string {
+	return new(Printer).PlainText(t)
+}
+
+// PlainText returns the text of t, such as the text of a [Paragraph]
+// or [Heading], with inline markup removed and runs of white space
+// collapsed to single spaces, for plain-text email or terminal output.
+// Non-breaking spaces (U+00A0) are not collapsed.
+// Links and images are replaced by their text,
+// and strikethrough text is written according to pr.PlainDel.
+func (pr *Printer) PlainText(t *Text) string {
+	p := printer{Printer: pr, writeMode: writeText}
+	t.Inline.printText(&p)
+	return strings.Join(strings.FieldsFunc(p.buf.String(), isBreakingSpace), " ")
+}
+
+// isBreakingSpace reports whether r is a white space character
+// other than the non-breaking space U+00A0.
+func isBreakingSpace(r rune) bool {
+	return r != '\u00a0' && unicode.IsSpace(r)
+}
+
+// frontMatterEnd returns the index of the first block in blocks
+// after any front matter. Front matter delimited by --- lines
+// parses as a --- thematic break on the first line, followed
+// directly by the front matter lines, which parse either as
+// a setext heading underlined by the closing --- or as
+// a single block followed directly by a closing --- thematic break.
+// If there is no front matter, frontMatterEnd returns 0.
+func frontMatterEnd(blocks []Block) int {
+	if len(blocks) < 2 {
+		return 0
+	}
+	if hr, ok := blocks[0].(*ThematicBreak); !ok || hr.StartLine != 1 || hr.Raw != "---" {
+		return 0
+	}
+	body := blocks[1].Pos()
+	if body.StartLine != 2 {
+		return 0
+	}
+	if h, ok := blocks[1].(*Heading); ok && h.Level == 2 && h.EndLine > h.StartLine {
+		return 2
+	}
+	if len(blocks) > 2 {
+		if hr, ok := blocks[2].(*ThematicBreak); ok && hr.Raw == "---" && hr.StartLine == body.EndLine+1 {
+			return 3
+		}
+	}
+	return 0
+}
+
 func (b *Document) printHTML(p *printer) {
-	for _, c := range b.Blocks {
+	printHTMLBlocks(p, b.Blocks)
+}
+
+// printHTMLBlocks prints the top-level blocks of a document,
+// applying the options that concern only top-level blocks:
+// [Printer.Sections], [Printer.SingleH1], [Printer.BlockHTML],
+// and [Printer.NoParagraphTags].
+func printHTMLBlocks(p *printer, blocks []Block) {
+	var sections []int // levels of open <section>s
+	for _, c := range blocks {
+		if h, ok := c.(*Heading); ok && p.Sections {
+			level := p.headingLevel(h)
+			for len(sections) > 0 && sections[len(sections)-1] >= level {
+				p.depth--
+				p.indentHTML()
+				p.html("</section>\n")
+				sections = sections[:len(sections)-1]
+			}
+			p.indentHTML()
+			p.html("<section>\n")
+			p.depth++
+			sections = append(sections, level)
+		}
+		p.topBlock = c
+		start := p.buf.Len()
 		c.printHTML(p)
+		if p.BlockHTML != nil {
+			html := p.BlockHTML(c, string(p.buf.Bytes()[start:]))
+			p.buf.Truncate(start)
+			p.html(html)
+		}
+	}
+	p.topBlock = nil
+	for range sections {
+		p.depth--
+		p.indentHTML()
+		p.html("</section>\n")
 	}
 }
 
 func (b *Document) printMarkdown(p *printer) {
-	printMarkdownBlocks(b.Blocks, p)
-
-	// Terminate with a single newline.
-	text := p.buf.Bytes()
-	w := len(text)
-	for w > 0 && text[w-1] == '\n' {
-		w--
+	links := b.Links
+	if p.DropUnusedLinks {
+		links = make(map[string]*Link)
+		for k, l := range b.Links {
+			if b.UsedLinks[k] {
+				links[k] = l
+			}
+		}
 	}
-	p.buf.Truncate(w)
-	if w > 0 {
+
+	// Reference links print as references
+	// only to the definitions printed below.
+	defer func(old map[string]*Link) { p.links = old }(p.links)
+	p.links = links
+
+	printMarkdownBlocks(b.Blocks, p)
+	trimNL(p)
+	if p.buf.Len() > 0 {
 		p.nl()
 	}
 
 	// Add link reference definitions.
-	if len(b.Links) > 0 {
+	if len(links) > 0 {
 		if p.buf.Len() > 0 {
 			p.nl()
 		}
-		printLinks(p, b.Links)
+		printLinks(p, links)
+	}
+
+	// Add footnotes, which would otherwise be added
+	// after the final newline, and mark them printed.
+	printFootnoteMarkdown(p)
+	p.footnotelist = p.footnotelist[:0]
+
+	// Terminate according to p.FinalNewline.
+	trimNL(p)
+	if p.buf.Len() > 0 {
+		switch p.FinalNewline {
+		case FinalNewlineSingle:
+			p.nl()
+		case FinalNewlinePreserve:
+			if !b.NoFinalNewline {
+				p.nl()
+			}
+		}
+	}
+}
+
+// trimNL removes trailing newlines from the output.
+func trimNL(p *printer) {
+	text := p.buf.Bytes()
+	w := len(text)
+	for w > 0 && text[w-1] == '\n' {
+		w--
 	}
+	p.buf.Truncate(w)
 }
 
 func printMarkdownBlocks(bs []Block, p *printer) {
 	for bn, b := range bs {
+		if p.MergeThematicBreaks && bn > 0 {
+			if _, ok := b.(*ThematicBreak); ok {
+				if _, ok := bs[bn-1].(*ThematicBreak); ok {
+					continue
+				}
+			}
+		}
 		if bn > 0 {
 			p.nl() // end block
 			if p.loose > 0 {
 				p.nl()
 			}
 		}
+		// Keep p.lastDelim only if it was set by the preceding block.
+		if bn == 0 {
+			p.lastDelim = 0
+		} else if l, ok := bs[bn-1].(*List); !ok || !l.Ordered() {
+			p.lastDelim = 0
+		}
+		if t, ok := b.(*Text); ok {
+			// Paragraph text in a tight list item.
+			p.sentences = p.SentencePerLine
+			t.printMarkdown(p)
+			p.sentences = false
+			continue
+		}
 		b.printMarkdown(p)
 	}
 }
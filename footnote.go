@@ -9,15 +9,25 @@ import (
 	"strings"
 )
 
+// A Footnote is a footnote definition, like
+//
+//	[^label]: Text of the footnote.
+//
+// parsed when [Parser.Footnote] is enabled.
+// Footnotes are not blocks in the document's Blocks;
+// they are listed in [Document.Notes] and
+// referred to by [FootnoteLink] inlines.
 type Footnote struct {
 	Position
-	Label  string
-	Blocks []Block
+	Label  string  // label as written, without [^ and ]
+	Blocks []Block // content of footnote
 }
 
+// A FootnoteLink is an [Inline] representing a reference
+// to a footnote, like [^label].
 type FootnoteLink struct {
-	Label    string
-	Footnote *Footnote
+	Label    string    // label as written, without [^ and ]
+	Footnote *Footnote // footnote being referenced
 }
 
 type printedNote struct {
@@ -54,9 +64,65 @@ func (x *FootnoteLink) printHTML(p *printer) {
 	if note == nil {
 		return
 	}
+	if p.inPopover {
+		// A reference in popover content links to the footnote
+		// but has no id, since the footnotes section prints
+		// the same reference again. It must not number the footnote,
+		// so that numbering does not depend on Printer.FootnotePopovers:
+		// a footnote not yet numbered is printed as its label instead.
+		pr := p.footnotes[note]
+		if pr == nil {
+			p.text(`[^`, x.Label, `]`)
+			return
+		}
+		p.html(`<sup class="fn"><a href="#fn-`, pr.num, `">`, pr.num, `</a></sup>`)
+		return
+	}
 	pr := note.printed(p)
 	ref := pr.refs[len(pr.refs)-1]
-	p.html(`<sup class="fn"><a id="fnref-`, ref, `" href="#fn-`, pr.num, `">`, pr.num, `</a></sup>`)
+	p.html(`<sup class="fn"><a id="fnref-`, ref, `" href="#fn-`, pr.num, `"`)
+	if p.ARIA {
+		p.html(` role="doc-noteref"`)
+	}
+	if p.FootnoteTitles {
+		if title := note.title(); title != "" {
+			p.html(` title="`)
+			p.text(title)
+			p.html(`"`)
+		}
+	}
+	p.html(`>`, pr.num, `</a></sup>`)
+	if p.FootnotePopovers {
+		note.printPopover(p)
+	}
+}
+
+// printPopover prints the hidden copy of the footnote's content
+// that follows each reference when [Printer.FootnotePopovers] is set.
+func (note *Footnote) printPopover(p *printer) {
+	p.inPopover = true
+	defer func() { p.inPopover = false }()
+	p.html(`<span class="fn-content" hidden>`)
+	sep := ""
+	for _, b := range note.Blocks {
+		if para, ok := b.(*Paragraph); ok {
+			p.html(sep)
+			para.Text.printHTML(p)
+			sep = " "
+		}
+	}
+	p.html(`</span>`)
+}
+
+// title returns the plain text of the first paragraph of the footnote,
+// for use as a title attribute (see [Printer.FootnoteTitles]).
+func (note *Footnote) title() string {
+	for _, b := range note.Blocks {
+		if para, ok := b.(*Paragraph); ok {
+			return plainText(para.Text)
+		}
+	}
+	return ""
 }
 
 func (x *FootnoteLink) printMarkdown(p *printer) {
@@ -72,35 +138,122 @@ func (x *FootnoteLink) printText(p *printer) {
 	p.text(`[^`, x.Label, `]`)
 }
 
+// RenderBodyHTML returns the HTML for the document without its
+// footnotes section, using the default [Printer] settings.
+// See [Printer.RenderBodyHTML].
+func (d *Document) RenderBodyHTML() string {
+	return new(Printer).RenderBodyHTML(d)
+}
+
+// RenderFootnotesHTML returns the HTML for the footnotes section
+// of the document, using the default [Printer] settings.
+// See [Printer.RenderFootnotesHTML].
+func (d *Document) RenderFootnotesHTML() string {
+	return new(Printer).RenderFootnotesHTML(d)
+}
+
+// RenderBodyHTML returns the HTML for doc without
+// the footnotes section that [Printer.ToHTML] appends at the end.
+// Use [Printer.RenderFootnotesHTML] to obtain that section separately.
+// The concatenation of the two results is the same as pr.ToHTML(doc).
+// Like ToHTML, RenderBodyHTML returns an empty string
+// if the HTML would be longer than pr.MaxOutputBytes.
+func (pr *Printer) RenderBodyHTML(doc *Document) (html string) {
+	p := printer{Printer: pr, writeMode: writeHTML}
+	var err error // ErrOutputTooLarge, reported as html == ""
+	defer p.recoverError(&err, false)
+	doc.printHTML(&p)
+	return p.buf.String()
+}
+
+// RenderFootnotesHTML returns the HTML for the footnotes section of doc,
+// or an empty string if doc has no footnote references.
+// The footnotes are numbered and linked to their references
+// exactly as in the HTML returned by [Printer.RenderBodyHTML].
+// Like [Printer.ToHTML], RenderFootnotesHTML returns an empty string
+// if the HTML would be longer than pr.MaxOutputBytes.
+func (pr *Printer) RenderFootnotesHTML(doc *Document) (html string) {
+	p := printer{Printer: pr, writeMode: writeHTML}
+	var err error // ErrOutputTooLarge, reported as html == ""
+	defer p.recoverError(&err, false)
+	p.numberFootnotes(doc)
+	printFootnoteHTML(&p)
+	return p.buf.String()
+}
+
+// numberFootnotes numbers the footnotes referred to in b
+// in the order that printing b as HTML would, without printing it.
+func (p *printer) numberFootnotes(b Block) {
+	Walk(b, nil, func(x Inline) bool {
+		switch x := x.(type) {
+		case *FootnoteLink:
+			if x.Footnote != nil {
+				x.Footnote.printed(p)
+			}
+		case *Image:
+			// Image alt text is printed as plain text,
+			// which does not number footnotes.
+			return false
+		}
+		return true
+	})
+}
+
 func printFootnoteHTML(p *printer) {
 	if len(p.footnotelist) == 0 {
 		return
 	}
 
+	p.indentHTML()
 	p.html(`<div class="footnotes">Footnotes</div>`, "\n")
-	p.html("<ol>\n")
+	p.indentHTML()
+	if p.ARIA {
+		p.html(`<ol role="doc-endnotes" aria-label="Footnotes">`, "\n")
+	} else {
+		p.html("<ol>\n")
+	}
 	for num, note := range p.footnotelist {
 		num++
 		str := strconv.Itoa(num)
+		p.depth++
+		p.indentHTML()
 		p.html(`<li id="fn-`, str, `">`, "\n")
+		p.depth++
 		for _, b := range note.note.Blocks {
 			b.printHTML(p)
 		}
 		if !p.eraseCloseP() {
+			p.indentHTML()
 			p.html("<p>\n")
 		}
 		for _, ref := range note.refs {
-			p.html("\n", `<a class="fnref" href="#fnref-`, ref, `">↩</a>`)
+			p.html("\n", `<a class="fnref" href="#fnref-`, ref, `"`)
+			if p.ARIA {
+				p.html(` role="doc-backlink" aria-label="Back to reference `, ref, `"`)
+			}
+			p.html(`>↩</a>`)
 		}
 		p.html("</p>\n")
+		p.depth--
+		p.indentHTML()
 		p.html("</li>\n")
+		p.depth--
 	}
+	p.indentHTML()
 	p.html("</ol>\n")
 }
 
 func (x *Footnote) printMarkdown(p *printer) {
 	p.md(`[^`, x.Label, `]: `)
-	defer p.pop(p.push("  "))
+
+	// Blocks after the first must be indented by four spaces
+	// and separated by blank lines, as in a loose list item.
+	defer p.pop(p.push("    "))
+	old := p.listOut
+	defer func() {
+		p.listOut = old
+	}()
+	p.loose, p.tight = 1, 0
 	printMarkdownBlocks(x.Blocks, p)
 }
 
@@ -134,6 +287,9 @@ func parseFootnoteRef(p *parser, s string, start int) (x Inline, end int, ok boo
 }
 
 func startFootnote(p *parser, s line) (line, bool) {
+	if !p.Footnote {
+		return s, false
+	}
 	t := s
 	t.trimSpace(0, 3, false)
 	if !t.trim('[') || !t.trim('^') {
@@ -159,7 +315,7 @@ func startFootnote(p *parser, s line) (line, bool) {
 		// dropping them from the document,
 		// but it seems more helpful to not treat it
 		// as a footnote.
-		p.corner = true
+		p.noteCorner("duplicate footnote definition")
 		return s, false
 	}
 
@@ -183,6 +339,8 @@ func (b *footnoteBuilder) build(p *parser) Block {
 	if p.footnotes == nil {
 		p.footnotes = make(map[string]*Footnote)
 	}
-	p.footnotes[normalizeLabel(b.label)] = &Footnote{p.pos(), b.label, p.blocks()}
+	note := &Footnote{p.pos(), b.label, p.blocks()}
+	p.footnotes[normalizeLabel(b.label)] = note
+	p.notes = append(p.notes, note)
 	return &Empty{}
 }
@@ -28,6 +28,12 @@ type Heading struct {
 	// The parser populates this field if [Parser.HeadingID] is true
 	// and the heading ends with text like "{#id}".
 	ID string
+
+	// Closing is the optional closing sequence of #s
+	// of an ATX heading, as in "## Heading ##".
+	// The parser records it so that Format can reproduce it
+	// when [Printer.MinimalDiff] is set.
+	Closing string
 }
 
 func (*Heading) Block() {}
@@ -37,21 +43,37 @@ func (h *Heading) level() int {
 	return max(1, min(6, h.Level))
 }
 
+// headingLevel returns the level at which p prints h,
+// which is h.level() except when [Printer.SingleH1] demotes it.
+func (p *printer) headingLevel(h *Heading) int {
+	level := h.level()
+	if level == 1 && p.SingleH1 && p.sawH1 {
+		level = 2
+	}
+	return level
+}
+
 func (b *Heading) printHTML(p *printer) {
-	fmt.Fprintf(p, "<h%d", b.level())
+	level := p.headingLevel(b)
+	p.sawH1 = p.sawH1 || level == 1
+	p.indentHTML()
+	fmt.Fprintf(p, "<h%d", level)
 	if b.ID != "" {
 		fmt.Fprintf(p, ` id="%s"`, htmlEscaper.Replace(b.ID))
 	}
+	p.html(p.class(fmt.Sprintf("h%d", level)))
 	p.WriteByte('>')
 	b.Text.printHTML(p)
-	fmt.Fprintf(p, "</h%d>\n", b.level())
+	fmt.Fprintf(p, "</h%d>\n", level)
 }
 
 func (b *Heading) printMarkdown(p *printer) {
 	p.maybeNL()
 
 	// TODO: handle setext headings properly.
-	for i := b.level(); i > 0; i-- {
+	level := p.headingLevel(b)
+	p.sawH1 = p.sawH1 || level == 1
+	for i := level; i > 0; i-- {
 		p.WriteByte('#')
 	}
 	p.WriteByte(' ')
@@ -59,20 +81,27 @@ func (b *Heading) printMarkdown(p *printer) {
 	if b.ID != "" {
 		fmt.Fprintf(p, " {#%s}", b.ID)
 	}
+	// The closing sequence must come last to be recognized,
+	// so it follows the {#id}, as in "# Heading {#id} ##".
+	if p.MinimalDiff && b.Closing != "" && strings.Trim(b.Closing, "#") == "" {
+		p.WriteString(" " + b.Closing)
+	}
 }
 
 // startATXHeading is a [starter] for an ATX [Heading], like "## Heading".
 //
 // See https://spec.commonmark.org/0.31.2/#atx-headings.
 func startATXHeading(p *parser, s line) (line, bool) {
-	n, ok := trimATX(&s)
+	n, ok := trimATX(&s, p.LaxHeadings)
 	if !ok {
 		return s, false
 	}
 	text := trimRightSpaceTab(s.string())
 
 	// Remove any number of trailing '#'s if preceded by a space or tab.
+	var closing string
 	if inner := strings.TrimRight(text, "#"); inner != trimRightSpaceTab(inner) || inner == "" {
+		closing = text[len(inner):]
 		text = inner
 	}
 
@@ -88,10 +117,44 @@ func startATXHeading(p *parser, s line) (line, bool) {
 	}
 
 	pos := Position{p.lineno, p.lineno}
-	p.doneBlock(&Heading{pos, n, p.newText(pos, text), id}) // TODO rename doneBlock?
+	if text == "" && p.headingOverflow(n) {
+		p.doneBlock(&Empty{pos})
+		return line{}, true
+	}
+	p.doneBlock(p.heading(&Heading{pos, n, p.newText(pos, text), id, closing})) // TODO rename doneBlock?
 	return line{}, true
 }
 
+// headingOverflow reports whether a heading at the given level
+// is too deep for [Parser.MaxHeadingLevel] and is to be
+// turned into a paragraph according to [Parser.HeadingOverflow].
+func (p *parser) headingOverflow(level int) bool {
+	return p.MaxHeadingLevel > 0 && level > p.MaxHeadingLevel && p.HeadingOverflow == HeadingOverflowStrong
+}
+
+// heading returns the block to use for the parsed heading h,
+// applying [Parser.MaxHeadingLevel] and [Parser.HeadingOverflow].
+func (p *parser) heading(h *Heading) Block {
+	if p.MaxHeadingLevel <= 0 || h.Level <= p.MaxHeadingLevel {
+		return h
+	}
+	if !p.headingOverflow(h.Level) {
+		h.Level = p.MaxHeadingLevel
+		return h
+	}
+	// If inline parsing is deferred, the empty Strong
+	// tells Text.ParseInline where to put the parsed text.
+	// Otherwise the fixup wraps the parsed text in the Strong.
+	t := h.Text
+	t.Inline = Inlines{&Strong{Marker: "**"}}
+	p.addFixup(func() {
+		if t.Raw == "" {
+			t.Inline = Inlines{&Strong{Marker: "**", Inner: t.Inline}}
+		}
+	})
+	return &Paragraph{h.Position, t}
+}
+
 // trimHeadingID trims an {#id} suffix from s if one is present,
 // returning the prefix before the {#id} and the id.
 // If there is no {#id} suffix, trimID returns s, "".
@@ -108,7 +171,7 @@ func trimHeadingID(p *parser, s string) (text, id string) {
 		return
 	}
 	if j == i+1 || j == i+2 && s[i+1] == '#' {
-		p.corner = true // goldmark accepts {} and {#}
+		p.noteCorner("empty heading id") // goldmark accepts {} and {#}
 		return
 	}
 	if s[i+1] != '#' {
@@ -116,10 +179,19 @@ func trimHeadingID(p *parser, s string) (text, id string) {
 	}
 	text, id = s[:i], strings.TrimSpace(s[i+2:j]) // TODO maybe trimSpace?
 
+	if p.HeadingIDFunc != nil {
+		var ok bool
+		if id, ok = p.HeadingIDFunc(id); !ok {
+			return s, ""
+		}
+		return text, id
+	}
+
 	// Goldmark is strict about the id syntax.
 	for i := range len(id) {
 		if c := id[i]; c >= 0x80 || !isLetterDigit(byte(c)) {
-			p.corner = true
+			p.noteCorner("heading id punctuation")
+			break
 		}
 	}
 
@@ -158,15 +230,17 @@ func startSetextHeading(p *parser, s line) (line, bool) {
 	}
 
 	p.deleteLast()
-	p.doneBlock(&Heading{Position{para.StartLine, p.lineno}, level, para.Text, ""})
+	p.doneBlock(p.heading(&Heading{Position{para.StartLine, p.lineno}, level, para.Text, "", ""}))
 	return line{}, true
 }
 
 // trimATX trims an ATX heading prefix
 // (optional spaces and then 1-6 #s followd by a space) from s.
 // reporting the heading level and whether it was successful.
+// If lax is true, the space after the #s is optional
+// (see [Parser.LaxHeadings]).
 // If trimATX is unsuccessful, it leaves s unmodified.
-func trimATX(s *line) (level int, ok bool) {
+func trimATX(s *line, lax bool) (level int, ok bool) {
 	t := *s
 	t.trimSpace(0, 3, false)
 	if !t.trim('#') {
@@ -176,7 +250,7 @@ func trimATX(s *line) (level int, ok bool) {
 	for n < 6 && t.trim('#') {
 		n++
 	}
-	if !t.trimSpace(1, 1, true) {
+	if !t.trimSpace(1, 1, true) && (!lax || t.peek() == '#') {
 		return
 	}
 	*s = t
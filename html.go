@@ -47,10 +47,32 @@ type htmlBuilder struct {
 	endBlank bool
 	endFunc  func(string) bool
 	text     []string //accumulated text
+	blanks   []string // pending blank lines (Parser.HTMLBlockBlankLines)
 }
 
 func (c *htmlBuilder) extend(p *parser, s line) (line, bool) {
+	if c.endBlank && p.HTMLBlockBlankLines {
+		// Hold blank lines until we see whether the block continues.
+		if s.isBlank() {
+			c.blanks = append(c.blanks, s.string())
+			return line{}, true
+		}
+		if len(c.blanks) > 0 {
+			t := s
+			t.trimSpace(0, 3, false)
+			if t.peek() != '<' {
+				c.blanks = nil
+				return s, false
+			}
+			c.text = append(c.text, c.blanks...)
+			c.blanks = nil
+		}
+	}
 	if c.endBlank && s.isBlank() {
+		// The blank line is not part of the block.
+		// Returning false leaves it for the enclosing blocks,
+		// so that in a list item or block quote it ends only the HTML block.
+		// See testdata/html7.txt.
 		return s, false
 	}
 	t := s.string()
@@ -277,7 +299,7 @@ func startHTMLBlock6(p *parser, s line, t string) bool {
 			if end < len(t) && t[end] == '\t' {
 				// Goldmark recognizes space but not tab.
 				// testdata/extra.txt 143.md
-				p.corner = true
+				p.noteCorner("html block tag followed by tab")
 			}
 			b := &htmlBuilder{endBlank: true}
 			p.addBlock(b)
@@ -305,7 +327,7 @@ func startHTMLBlock7(p *parser, s line, t string) bool {
 	if _, end, ok := parseHTMLOpenTag(p, t, 0); ok && skipSpace(t, end) == len(t) {
 		if end != len(t) {
 			// Goldmark disallows trailing space
-			p.corner = true
+			p.noteCorner("html block trailing space")
 		}
 		b := &htmlBuilder{endBlank: true}
 		p.addBlock(b)
@@ -369,7 +391,7 @@ func parseHTMLOpenTag(p *parser, s string, i int) (x Inline, end int, ok bool) {
 	case "pre", "script", "style", "textarea":
 		// Goldmark treats these as starting a new HTMLBlock
 		// and ending the paragraph they appear in.
-		p.corner = true
+		p.noteCorner("inline raw text tag")
 	}
 
 	// zero or more attributes
@@ -388,7 +410,7 @@ func parseHTMLOpenTag(p *parser, s string, i int) (x Inline, end int, ok bool) {
 	k := skipSpace(s, j)
 	if k != j {
 		// Goldmark mishandles spaces before >.
-		p.corner = true
+		p.noteCorner("html tag space before >")
 	}
 	j = k
 
@@ -415,7 +437,7 @@ func parseHTMLClosingTag(p *parser, s string, i int) (x Inline, end int, ok bool
 	}
 	if skipSpace(s, i+2) != i+2 {
 		// Goldmark allows spaces here but the spec and the Dingus do not.
-		p.corner = true
+		p.noteCorner("html closing tag space after </")
 	}
 
 	if _, j, ok := parseTagName(s, i+2); ok {
@@ -554,7 +576,7 @@ func parseHTMLDecl(p *parser, s string, i int) (x Inline, end int, ok bool) {
 	// zero or more characters not including the character >, and the character >.”
 	if i+2 < len(s) && isLetter(s[i+2]) {
 		if 'a' <= s[i+2] && s[i+2] <= 'z' {
-			p.corner = true // goldmark requires uppercase
+			p.noteCorner("html declaration lower case") // goldmark requires uppercase
 		}
 		return parseHTMLMarker(p, s, i, "<!", ">")
 	}
@@ -4,7 +4,12 @@
 
 package markdown
 
-import "strings"
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
 
 // htmlEscaper is a strings.Replacer that escapes text for inclusion in HTML.
 // It escapes " & < > only. In particular it does not escape ' so any generated
@@ -16,6 +21,28 @@ var htmlEscaper = strings.NewReplacer(
 	">", "&gt;",
 )
 
+// writeNonASCII writes s to buf, replacing each non-ASCII rune
+// with a hexadecimal numeric character reference.
+// It is used for [Printer.EscapeNonASCII].
+func writeNonASCII(buf *bytes.Buffer, s string) {
+	for s != "" {
+		i := 0
+		for i < len(s) && s[i] < utf8.RuneSelf {
+			i++
+		}
+		buf.WriteString(s[:i])
+		s = s[i:]
+		if s == "" {
+			break
+		}
+		r, size := utf8.DecodeRuneInString(s)
+		buf.WriteString("&#x")
+		buf.WriteString(strings.ToUpper(strconv.FormatInt(int64(r), 16)))
+		buf.WriteString(";")
+		s = s[size:]
+	}
+}
+
 // htmlLinkEscaper is a strings.Replacer that escapes URLs
 // for inclusion in an <a href="..."> tag.
 var htmlLinkEscaper = strings.NewReplacer(
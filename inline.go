@@ -5,7 +5,9 @@
 package markdown
 
 import (
+	"slices"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -61,24 +63,133 @@ func (x *Plain) printHTML(p *printer) { p.text(x.Text) }
 func (x *Plain) printMarkdown(p *printer) {
 	// TODO: This is wrong if Plain contains characters that should be escaped.
 	// Today that doesn't happen for our own parses, but constructed syntax trees
-	// might contain them.
+	// might contain them. EscapeSet.Text lets callers choose what to escape.
 	// Deciding exactly what to escape is (or probably should be) somewhat context dependent.
-	for i, line := range strings.Split(x.Text, "\n") {
+	set := p.escapes().Text
+	lines := strings.Split(x.Text, "\n")
+	for i, line := range lines {
 		if i > 0 {
 			p.nl()
 		}
+		if strings.IndexByte(set, '\\') < 0 {
+			line = escapeBackslash(line, i == len(lines)-1 && p.lastInline == x)
+		}
+		line = escapePunct(line, set)
+		if p.escapeTicks && strings.IndexByte(set, '`') < 0 {
+			line = mdTickEscaper.Replace(line)
+		}
+		if p.sentences {
+			for j, s := range splitSentences(line) {
+				if j > 0 {
+					p.nl()
+				}
+				p.WriteString(s)
+			}
+			p.noTrim()
+			continue
+		}
 		p.WriteString(line)
 		p.noTrim()
 	}
 }
 
+// escapeBackslash returns s with a backslash inserted before
+// each backslash that would otherwise begin a backslash escape,
+// such as in the Windows path C:\*.txt, so that the text round-trips.
+// A backslash at the end of s begins an escape or a hard line break
+// if more text follows, so it too is escaped, unless final is true,
+// meaning nothing follows s in the enclosing [Text].
+// Other backslashes, such as those in C:\Users, are left alone.
+func escapeBackslash(s string, final bool) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && (i+1 < len(s) && isPunct(s[i+1]) || i+1 == len(s) && !final) {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// splitSentences splits s after each sentence-ending
+// punctuation mark (. ! or ?, possibly followed by closing
+// quotes, parentheses, or brackets) that is followed by spaces
+// and then an upper-case letter, removing the spaces.
+// Requiring an upper-case letter avoids splitting in text like "1.5 times"
+// and ensures that the new line cannot start a block,
+// such as a list item or a heading.
+func splitSentences(s string) []string {
+	var list []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c != '.' && c != '!' && c != '?' {
+			continue
+		}
+		j := i + 1
+		for j < len(s) && strings.IndexByte(`"')]`, s[j]) >= 0 {
+			j++
+		}
+		k := j
+		for k < len(s) && s[k] == ' ' {
+			k++
+		}
+		if k == j || k == len(s) {
+			continue
+		}
+		if r, _ := utf8.DecodeRuneInString(s[k:]); !unicode.IsUpper(r) {
+			continue
+		}
+		list = append(list, s[start:j])
+		start = k
+		i = k - 1
+	}
+	return append(list, s[start:])
+}
+
+// mdTickEscaper escapes backticks in plain text.
+var mdTickEscaper = strings.NewReplacer("`", "\\`")
+
+// hasCode reports whether x is or contains a [Code].
+func hasCode(x Inline) bool {
+	found := false
+	walkInline(x, func(y Inline) bool {
+		if _, ok := y.(*Code); ok {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
 // An Escaped is an [Inline] that represents a [backslash escaped symbol].
+// When [Parser.NonBreakingSpace] is set, an escaped space (\ followed
+// by a space) is also an Escaped, with Text " ", representing
+// a non-breaking space: it renders as &nbsp; in HTML and as U+00A0 in text.
 //
 // [backslash escaped symbol]: https://spec.commonmark.org/0.31.2/#backslash-escapes
 type Escaped struct {
 	Plain // single character text (omitting the escaping backslash)
 }
 
+func (x *Escaped) printHTML(p *printer) {
+	if x.Text == " " {
+		p.html("&nbsp;")
+		return
+	}
+	p.text(x.Text)
+}
+
+func (x *Escaped) printText(p *printer) {
+	if x.Text == " " {
+		p.text("\u00a0")
+		return
+	}
+	p.text(x.Text)
+}
+
 func (x *Escaped) printMarkdown(p *printer) {
 	p.md(`\`)
 	p.md(x.Text)
@@ -88,7 +199,8 @@ func (x *Escaped) printMarkdown(p *printer) {
 //
 // [code span]: https://spec.commonmark.org/0.31.2/#code-spans
 type Code struct {
-	Text string
+	Position // set only when [Parser.InlinePositions] is enabled
+	Text     string
 }
 
 func (*Code) Inline() {}
@@ -111,7 +223,13 @@ func (x *Code) printMarkdown(p *printer) {
 	// Note: len(x.Text)==0 is not possible to express in Markdown,
 	// but if someone makes a buggy Code, we print it as ` ` (a code-formatted space),
 	// since the only other choice would be to not print any code text at all, which is worse.
-	space := len(x.Text) == 0 || x.Text[0] == '`' || x.Text[len(x.Text)-1] == '`'
+	//
+	// Otherwise, add a space on each side when the text begins or ends with a backtick,
+	// so that the backtick does not merge with the delimiters,
+	// or when the text begins and ends with a space and is not all spaces,
+	// because the parser removes one space from each end of such text.
+	space := len(x.Text) == 0 || x.Text[0] == '`' || x.Text[len(x.Text)-1] == '`' ||
+		len(x.Text) >= 2 && x.Text[0] == ' ' && x.Text[len(x.Text)-1] == ' ' && trimSpace(x.Text) != ""
 	if space {
 		p.WriteByte(' ')
 	}
@@ -151,7 +269,7 @@ func printTicks(p *printer, n int) {
 //
 // [strong emphasis]: https://spec.commonmark.org/0.31.2/#emphasis-and-strong-emphasis
 type Strong struct {
-	Marker string
+	Marker string // delimiter as written, "**" or "__"; Format reproduces it
 	Inner  Inlines
 }
 
@@ -175,7 +293,7 @@ func (x *Strong) printMarkdown(p *printer) {
 //
 // [emphasis]: https://spec.commonmark.org/0.31.2/#emphasis-and-strong-emphasis
 type Emph struct {
-	Marker string
+	Marker string // delimiter as written, "*" or "_"; Format reproduces it
 	Inner  Inlines
 }
 
@@ -206,7 +324,37 @@ type Del struct {
 
 func (*Del) Inline() {}
 
-func (x *Del) printText(p *printer) { x.Inner.printText(p) }
+func (x *Del) printText(p *printer) {
+	if p.writeMode != writeText {
+		// Alt text and the like: just the text.
+		x.Inner.printText(p)
+		return
+	}
+	switch p.PlainDel {
+	default:
+		x.Inner.printText(p)
+	case PlainDelMarkers:
+		marker := x.Marker
+		if marker == "" {
+			marker = "~~"
+		}
+		p.text(marker)
+		x.Inner.printText(p)
+		p.text(marker)
+	case PlainDelCombining:
+		start := p.buf.Len()
+		x.Inner.printText(p)
+		text := string(p.buf.Bytes()[start:])
+		p.buf.Truncate(start)
+		for _, r := range text {
+			p.buf.WriteRune(r)
+			if !unicode.IsSpace(r) {
+				p.buf.WriteRune('\u0336')
+			}
+		}
+		p.checkSize()
+	}
+}
 
 func (x *Del) printHTML(p *printer) {
 	p.html("<del>")
@@ -300,6 +448,31 @@ func (p *parser) emit(i int) {
 	}
 }
 
+// setInlinePos sets the Position of x, if x has one,
+// to the lines spanned by p.s[start:end],
+// when [Parser.InlinePositions] is enabled.
+// The lines are counted from p.lineno, the first line of the text.
+func (p *parser) setInlinePos(x Inline, start, end int) {
+	if !p.InlinePositions {
+		return
+	}
+	line := func(off int) int {
+		n, _ := slices.BinarySearch(p.nls, off)
+		return p.lineno + n
+	}
+	pos := Position{line(start), line(end - 1)}
+	switch x := x.(type) {
+	case *Link:
+		x.Position = pos
+	case *Image:
+		x.Position = pos
+	case *AutoLink:
+		x.Position = pos
+	case *Code:
+		x.Position = pos
+	}
+}
+
 // skip sets p.emitted = i.
 func (p *parser) skip(i int) {
 	p.emitted = i
@@ -336,6 +509,14 @@ func (p *parser) inline(s string) Inlines {
 	p.s = s
 	p.list = nil
 	p.emitted = 0
+	p.nls = p.nls[:0]
+	if p.InlinePositions {
+		for i := 0; i < len(s); i++ {
+			if s[i] == '\n' {
+				p.nls = append(p.nls, i)
+			}
+		}
+	}
 
 	// Scan text looking for inlines.
 	// Leaf inlines are converted immediately.
@@ -346,7 +527,17 @@ func (p *parser) inline(s string) Inlines {
 	var ignoreLinkBefore int // ignore link openings before this stack offset, to avoid links inside links
 	backticksReset := false  // for lazy initialization of p.backticks
 
+	nextCheck := ctxCheckInline
 	for off := 0; off < len(s); {
+		// Check for cancellation now and then,
+		// so that one huge paragraph cannot run past a deadline.
+		if off >= nextCheck {
+			if p.canceled() {
+				return nil
+			}
+			nextCheck = off + ctxCheckInline
+		}
+
 		// Determine the parser based on leading character.
 		var parser inlineParser
 		switch s[off] {
@@ -385,7 +576,9 @@ func (p *parser) inline(s string) Inlines {
 		case '\n': // TODO what about eof
 			parser = parseBreak
 		case '&':
-			parser = parseHTMLEntity
+			if !p.PreserveEntities {
+				parser = parseHTMLEntity
+			}
 		case ':':
 			if p.Emoji {
 				parser = parseEmoji
@@ -403,6 +596,7 @@ func (p *parser) inline(s string) Inlines {
 					opens = append(opens, len(p.list))
 				}
 				p.list = append(p.list, x)
+				p.setInlinePos(x, off, end)
 
 				// Skip over x's extent in future plain text emits.
 				p.skip(end)
@@ -422,19 +616,46 @@ func (p *parser) inline(s string) Inlines {
 			// Match to the openPlain in the list.
 			// An image is valid anywhere; a link is only valid if it starts
 			// after ignoreLinkBefore, to avoid links containing links.
+			// Disabled links and images leave their brackets as plain text.
 			open := p.list[oi].(*openPlain)
-			if open.i >= ignoreLinkBefore || open.Text[0] == '!' {
-				if x, end, ok := parseLinkClose(p, s, off, open); ok {
+			if open.Text[0] == '!' && !p.NoImages || open.Text[0] == '[' && !p.NoLinks && open.i >= ignoreLinkBefore {
+				x, end, ok := parseLinkClose(p, s, off, open)
+				if !ok && open.Text[0] == '[' && p.UnresolvedLinks != UnresolvedLinksLiteral {
+					if e, found := unresolvedLinkEnd(p, s, off); found {
+						// Report the line of the reference, not the first line of the text,
+						// for diagnostics like mdfmt -lint.
+						p.cornerAt(p.lineno+strings.Count(s[:open.i], "\n"), "unresolved link reference")
+						if p.UnresolvedLinks == UnresolvedLinksText {
+							// Replace the link with its text.
+							p.emit(off)
+							p.list = append(p.list[:oi], p.emph(nil, p.list[oi+1:])...)
+							p.skip(e)
+							off = e
+							continue
+						}
+						x, end, ok = &Link{URL: p.UnresolvedLinkURL}, e, true
+					}
+				}
+				if ok {
 					p.emit(off)
-					x.Inner = p.emph(nil, p.list[oi+1:])
-					if open.Text[0] == '!' {
-						// parseLinkClose always returns a *Link.
-						// By design, Link and Image are the same underlying struct,
-						// so we can convert to *Image here.
-						p.list[oi] = (*Image)(x)
-					} else {
-						p.list[oi] = x
+					p.setInlinePos(x, open.i-len(open.Text), end)
+					inner := p.emph(nil, p.list[oi+1:])
+					var url string
+					switch x := x.(type) {
+					case *Link:
+						x.Inner = inner
+						url = x.URL
+						if p.OnLink != nil {
+							p.OnLink(x)
+						}
+					case *Image:
+						x.Inner = inner
+						url = x.URL
+						if p.OnImage != nil {
+							p.OnImage(x)
+						}
 					}
+					p.list[oi] = x
 					p.list = p.list[:oi+1]
 					p.skip(end)
 					off = end
@@ -445,10 +666,10 @@ func (p *parser) inline(s string) Inlines {
 
 					// Goldmark and the Dingus re-escape invalid-looking percents as %25,
 					// but the spec does not seem to require this behavior.
-					url := x.URL
+					// Printer.EscapeInvalidPercent enables it in HTML output.
 					for i := 0; i < len(url); i++ {
 						if url[i] == '%' && (i+2 >= len(url) || !isHexDigit(url[i+1]) || !isHexDigit(url[i+2])) {
-							p.corner = true
+							p.noteCorner("link url invalid percent")
 							break
 						}
 					}
@@ -709,17 +930,36 @@ Src:
 	return ps.mergePlain(dst)
 }
 
+// afterClose reports whether the end of s looks like the end of a word
+// or of a closing construct, meaning that a single quote following s
+// should be treated as an apostrophe rather than an opening quote.
+// Trailing emphasis delimiters and quotes are skipped over,
+// so that in *foo*'s and "foo"'s the check applies to the o,
+// while in *'foo'* and "'foo'" it applies to the start of the text.
+func afterClose(s string) bool {
+	s = strings.TrimRight(s, "*_~'\"")
+	if s == "" {
+		return false
+	}
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return !isUnicodeSpace(r) && !isUnicodePunct(r) || strings.ContainsRune(")]}.,;:!?`", r)
+}
+
 // parseEscape is an [inlineParser] for an [Escaped] or [HardBreak].
+// See also [Parser.NonBreakingSpace].
 func parseEscape(p *parser, s string, start int) (x Inline, end int, ok bool) {
 	if start+1 < len(s) {
 		c := s[start+1]
 		end = start + 2
-		if isPunct(c) {
+		if isPunct(c) || c == ' ' && p.NonBreakingSpace {
 			return &Escaped{Plain{s[start+1 : end]}}, end, true
 		}
 		if c == '\n' { // TODO what about eof
 			if start > 0 && s[start-1] == '\\' {
-				p.corner = true // goldmark mishandles \\\ newline
+				// The backslashes before this one were escaped backslashes,
+				// so this one is a hard line break, as in the spec and the Dingus.
+				// See testdata/backslash.txt.
+				p.noteCorner("backslash backslash newline") // goldmark mishandles \\\ newline
 			}
 			return &HardBreak{}, end, true
 		}
@@ -730,14 +970,18 @@ func parseEscape(p *parser, s string, start int) (x Inline, end int, ok bool) {
 // parseAutoLinkOrHTML is an [inlineParser] for a Markdown autolink (not GitHub autolink)
 // or an HTML tag. The caller has checked that s[start] == '<'.
 func parseAutoLinkOrHTML(p *parser, s string, start int) (x Inline, end int, ok bool) {
-	if x, end, ok = parseAutoLinkURI(s, start); ok {
-		return
-	}
-	if x, end, ok = parseAutoLinkEmail(s, start); ok {
-		return
+	if !p.NoAutoLinks {
+		if x, end, ok = parseAutoLinkURI(s, start); ok {
+			return
+		}
+		if x, end, ok = parseAutoLinkEmail(s, start); ok {
+			return
+		}
 	}
-	if x, end, ok = parseHTMLTag(p, s, start); ok {
-		return
+	if !p.NoInlineHTML {
+		if x, end, ok = parseHTMLTag(p, s, start); ok {
+			return
+		}
 	}
 	return
 }
@@ -880,7 +1124,7 @@ func (b *backtickParser) parseCodeSpan(p *parser, s string, start int) (x Inline
 				text = text[1 : len(text)-1]
 			}
 
-			return &Code{text}, end, true
+			return &Code{Text: text}, end, true
 		}
 	}
 	b.scanned = true
@@ -903,11 +1147,14 @@ func parseEmph(p *parser, s string, start int) (x Inline, end int, ok bool) {
 			end++
 		}
 	}
+	if c == '~' && end-start == 1 && p.NoSingleTildeStrikethrough {
+		return &Plain{s[start:end]}, end, true
+	}
 	if c == '~' && end-start != 2 {
+		// GitHub accepts ~text~ and ~~text~~ but not ~~~text~~~.
 		// Goldmark does not accept ~text~
 		// and incorrectly accepts ~~~text~~~.
-		// Only ~~ is correct.
-		p.corner = true
+		p.noteCorner("strikethrough not ~~")
 	}
 	if c == '~' && end-start > 2 {
 		// Skip over all the ~ so that we don't see
@@ -952,7 +1199,18 @@ func parseEmph(p *parser, s string, start int) (x Inline, end int, ok bool) {
 	switch c {
 	case '\'', '"':
 		canOpen = leftFlank && !rightFlank && before != ']' && before != ')'
+		if c == '\'' && canOpen && afterClose(s[:start]) {
+			// An apostrophe following closing punctuation,
+			// as in "foo"'s or *foo*'s or foo.'s,
+			// is a contraction or possessive, not an opening quote.
+			canOpen = false
+		}
 		canClose = rightFlank
+		if c == '\'' && leftFlank && rightFlank && !isUnicodePunct(before) && !isUnicodePunct(after) {
+			// An apostrophe inside a word, as in it's,
+			// is neither an opening nor a closing quote.
+			canClose = false
+		}
 	case '*', '~':
 		// “A single * character can open emphasis iff
 		// it is part of a left-flanking delimiter run.”
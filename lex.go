@@ -5,6 +5,7 @@
 package markdown
 
 import (
+	"slices"
 	"strings"
 	"unicode"
 )
@@ -39,18 +40,18 @@ func isHexDigit(c byte) bool {
 	return 'A' <= c && c <= 'F' || 'a' <= c && c <= 'f' || '0' <= c && c <= '9'
 }
 
-// isUnocdeSpace reports whether r is a Unicode space as defined by Markdown.
+// isUnicodeSpace reports whether r is a Unicode space as defined by Markdown.
 // This is not the same as unicode.IsSpace.
 // For example, U+0085 does not satisfy isUnicodeSpace
 // but does satisfy unicode.IsSpace.
 func isUnicodeSpace(r rune) bool {
 	if r < 0x80 {
-		return r == ' ' || r == '\t' || r == '\f' || r == '\n'
+		return r == ' ' || r == '\t' || r == '\f' || r == '\n' || r == '\r'
 	}
 	return unicode.In(r, unicode.Zs)
 }
 
-// isUnocdeSpace reports whether r is Unicode punctuation as defined by Markdown.
+// isUnicodePunct reports whether r is Unicode punctuation as defined by Markdown.
 // This is not the same as unicode.Punct; it also includes unicode.Symbol.
 func isUnicodePunct(r rune) bool {
 	if r < 0x80 {
@@ -69,29 +70,32 @@ func skipSpace(s string, i int) int {
 	return i
 }
 
-// mdEscaper escapes symbols that are used in inline Markdown sequences.
-// TODO(rsc): There is a better way to do this.
-var mdEscaper = strings.NewReplacer(
-	`(`, `\(`,
-	`)`, `\)`,
-	`[`, `\[`,
-	`]`, `\]`,
-	`*`, `\*`,
-	`_`, `\_`,
-	`<`, `\<`,
-	`>`, `\>`,
-)
-
-// mdLinkEscaper escapes symbols that have meaning inside a link target.
-var mdLinkEscaper = strings.NewReplacer(
-	`(`, `\(`,
-	`)`, `\)`,
-	`<`, `\<`,
-	`>`, `\>`,
-)
+// escapePunct returns s with a backslash inserted
+// before each byte that appears in the ASCII string set.
+func escapePunct(s, set string) string {
+	if set == "" || !strings.ContainsAny(s, set) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(set, s[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
 
-// mdUnscape returns the Markdown unescaping of s.
-func mdUnescape(s string) string {
+// unescape returns the Markdown unescaping of s,
+// which decodes backslash escapes and, unless [Parser.PreserveEntities]
+// is set, HTML entities.
+func (p *parser) unescape(s string) string {
+	if p.PreserveEntities {
+		if !strings.Contains(s, `\`) {
+			return s
+		}
+		return mdBackslashUnescaper.Replace(s)
+	}
 	if !strings.Contains(s, `\`) && !strings.Contains(s, `&`) {
 		return s
 	}
@@ -101,43 +105,49 @@ func mdUnescape(s string) string {
 // mdUnescaper unescapes Markdown escape sequences and HTML entities.
 // TODO(rsc): Perhaps there is a better way to do this.
 var mdUnescaper = func() *strings.Replacer {
-	var list = []string{
-		`\!`, `!`,
-		`\"`, `"`,
-		`\#`, `#`,
-		`\$`, `$`,
-		`\%`, `%`,
-		`\&`, `&`,
-		`\'`, `'`,
-		`\(`, `(`,
-		`\)`, `)`,
-		`\*`, `*`,
-		`\+`, `+`,
-		`\,`, `,`,
-		`\-`, `-`,
-		`\.`, `.`,
-		`\/`, `/`,
-		`\:`, `:`,
-		`\;`, `;`,
-		`\<`, `<`,
-		`\=`, `=`,
-		`\>`, `>`,
-		`\?`, `?`,
-		`\@`, `@`,
-		`\[`, `[`,
-		`\\`, `\`,
-		`\]`, `]`,
-		`\^`, `^`,
-		`\_`, `_`,
-		"\\`", "`",
-		`\{`, `{`,
-		`\|`, `|`,
-		`\}`, `}`,
-		`\~`, `~`,
-	}
-
+	list := slices.Clone(mdBackslashEscapes)
 	for name, repl := range htmlEntity {
 		list = append(list, name, repl)
 	}
 	return strings.NewReplacer(list...)
 }()
+
+// mdBackslashUnescaper unescapes Markdown escape sequences only.
+var mdBackslashUnescaper = strings.NewReplacer(mdBackslashEscapes...)
+
+// mdBackslashEscapes lists the Markdown backslash escapes
+// and their replacements, as arguments to [strings.NewReplacer].
+var mdBackslashEscapes = []string{
+	`\!`, `!`,
+	`\"`, `"`,
+	`\#`, `#`,
+	`\$`, `$`,
+	`\%`, `%`,
+	`\&`, `&`,
+	`\'`, `'`,
+	`\(`, `(`,
+	`\)`, `)`,
+	`\*`, `*`,
+	`\+`, `+`,
+	`\,`, `,`,
+	`\-`, `-`,
+	`\.`, `.`,
+	`\/`, `/`,
+	`\:`, `:`,
+	`\;`, `;`,
+	`\<`, `<`,
+	`\=`, `=`,
+	`\>`, `>`,
+	`\?`, `?`,
+	`\@`, `@`,
+	`\[`, `[`,
+	`\\`, `\`,
+	`\]`, `]`,
+	`\^`, `^`,
+	`\_`, `_`,
+	"\\`", "`",
+	`\{`, `{`,
+	`\|`, `|`,
+	`\}`, `}`,
+	`\~`, `~`,
+}
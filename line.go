@@ -4,17 +4,27 @@
 
 package markdown
 
+import "strings"
+
+// A line is a single input line being processed by the block parser.
+//
+// Block prefixes are removed from the front of the line by advancing i.
+// A tab in the input advances to the next tab stop, which may be more columns
+// than a prefix needs, so a tab can be only partly consumed.
+// In that case the tab is skipped in text and the columns it still covers
+// are recorded in spaces, which behave as leading spaces not present in text.
 type line struct {
-	spaces   int
-	i        int
-	tab      int
+	spaces   int // virtual spaces left over from a partly consumed tab
+	i        int // index of next byte to process in text
+	tab      int // index in text of the most recent tab stop at or before i
+	tabWidth int // width of tab stops; 0 means 4
 	text     string
 	nl       byte // newline character ending this line: \r or \n or \r+\n or zero for EOF
 	nonblank int  // index of first non-space, non-tab char in text; len(text) if none
 }
 
-func makeLine(text string, nl byte) line {
-	s := line{text: text, nl: nl}
+func makeLine(text string, nl byte, tabWidth int) line {
+	s := line{text: text, nl: nl, tabWidth: tabWidth}
 	s.setNonblank()
 	return s
 }
@@ -61,9 +71,20 @@ func (s *line) trimSpace(min, max int, eolOK bool) bool {
 		if t.i < len(t.text) {
 			switch t.text[t.i] {
 			case '\t':
-				t.spaces = 4 - (t.i-t.tab)&3 - 1
+				// The tab runs from its column to the next tab stop.
+				// Every byte between the last tab stop (t.tab) and the tab
+				// occupies a single column, so t.i-t.tab is the tab's column
+				// relative to that stop, and the tab covers the remaining
+				// w - (t.i-t.tab)%w columns. One of those columns is consumed now;
+				// the rest become virtual spaces.
+				// After the tab, t.i is itself at a tab stop.
+				w := t.tabWidth
+				if w <= 0 {
+					w = 4
+				}
+				t.spaces = w - (t.i-t.tab)%w - 1
 				t.i++
-				t.tab = t.i // TODO seems wrong
+				t.tab = t.i
 				continue
 			case ' ':
 				t.i++
@@ -118,8 +139,8 @@ func (s *line) string() string {
 	case 3:
 		return "   " + s.text[s.i:]
 	}
-	// unreachable
-	panic("bad spaces")
+	// Only possible with tab widths greater than 4.
+	return strings.Repeat(" ", s.spaces) + s.text[s.i:]
 }
 
 func trimLeftSpaceTab(s string) string {
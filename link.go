@@ -5,6 +5,7 @@
 package markdown
 
 import (
+	"encoding/base64"
 	"fmt"
 	"slices"
 	"strings"
@@ -13,36 +14,74 @@ import (
 	"golang.org/x/text/cases"
 )
 
-// Note: Link and Image are the same underlying struct by design,
-// so that code can safely convert between *Link and *Image.
-
 // A Link is an [Inline] representing a [link] (<a> tag).
 //
 // [link]: https://spec.commonmark.org/0.31.2/#links
 type Link struct {
+	Position  // set only when [Parser.InlinePositions] is enabled
 	Inner     Inlines
 	URL       string
 	Title     string
 	TitleChar byte // ', " or )
+
+	// Label is the label of a link reference definition
+	// stored in [Document.Links], as written in the input
+	// (without the brackets and before normalization).
+	// For a reference link in text, Label is the label
+	// of the definition it refers to, as written in the reference,
+	// and Ref records the form of the reference.
+	// Label is empty for inline links like [text](url).
+	Label string
+	Ref   RefForm
+
+	// Bare records that the link was written in the input as a bare
+	// URL or email address and linked by [Parser.AutoLinkText].
+	// Format prints such a link as the bare text again,
+	// provided its URL still ends with that text.
+	Bare bool
 }
 
 // An Image is an [Inline] representing an [image] (<a> tag).
 //
 // [image]: https://spec.commonmark.org/0.31.2/#images
 type Image struct {
+	Position  // set only when [Parser.InlinePositions] is enabled
 	Inner     Inlines
 	URL       string
 	Title     string
 	TitleChar byte
+
+	// Label and Ref record the label and form of a reference image,
+	// like ![text][label], as for [Link.Label].
+	Label string
+	Ref   RefForm
+
+	// Width and Height are the image dimensions given using
+	// the =WxH syntax enabled by [Parser.ImageSize].
+	// Either or both can be empty, meaning unspecified.
+	Width  string
+	Height string
 }
 
 func (*Link) Inline() {}
 
+// A RefForm is the form of a reference link or image,
+// recorded in [Link.Ref] and [Image.Ref] so that
+// Format can print the reference as it was written.
+type RefForm int
+
+const (
+	RefNone      RefForm = iota // not a reference: [text](url), or a definition
+	RefFull                     // full reference: [text][label]
+	RefCollapsed                // collapsed reference: [label][]
+	RefShortcut                 // shortcut reference: [label]
+)
+
 func (x *Link) printHTML(p *printer) {
-	p.html(`<a href="`, htmlLinkEscaper.Replace(x.URL), `"`)
+	p.html(`<a href="`, htmlLinkEscaper.Replace(p.url(x.URL)), `"`)
 	if x.Title != "" {
 		p.html(" title=\"")
-		p.html(htmlEscaper.Replace(x.Title))
+		p.text(x.Title)
 		p.html("\"")
 	}
 	p.html(">")
@@ -53,20 +92,69 @@ func (x *Link) printHTML(p *printer) {
 }
 
 func (x *Link) printMarkdown(p *printer) {
+	defer func(old bool) { p.sentences = old }(p.sentences)
+	p.sentences = false // leave link text intact
+	if x.Bare && len(x.Inner) == 1 {
+		if t, ok := x.Inner[0].(*Plain); ok && t.Text != "" && strings.HasSuffix(x.URL, t.Text) {
+			p.WriteString(t.Text)
+			return
+		}
+	}
+	printLinkMarkdown(p, x, "")
+}
+
+// printLinkMarkdown prints x, preferring the reference form recorded in x.Ref.
+// If size is not empty, it is an image size like "=WxH",
+// and x is printed as an inline link with that size.
+func printLinkMarkdown(p *printer, x *Link, size string) {
+	if size == "" && x.printReference(p) {
+		return
+	}
 	p.WriteByte('[')
 	for _, c := range x.Inner {
 		c.printMarkdown(p)
 	}
 	p.WriteString("](")
-	u := mdLinkEscaper.Replace(x.URL)
-	if u == "" || strings.ContainsAny(u, " ") {
-		u = "<" + u + ">"
+	p.WriteString(escapeURL(x.URL, p.escapes().URL))
+	if size != "" {
+		p.WriteString(" " + size)
 	}
-	p.WriteString(u)
 	printLinkTitleMarkdown(p, x.Title, x.TitleChar)
 	p.WriteByte(')')
 }
 
+// printReference prints x as a reference link in the form recorded in x.Ref
+// and reports whether it did. It prints nothing and returns false
+// unless the document being printed has a definition for x.Label
+// with x's URL and title, so that changes to x are not lost.
+// A collapsed or shortcut reference whose printed text
+// no longer matches its label is printed as a full reference.
+func (x *Link) printReference(p *printer) bool {
+	key := normalizeLabel(x.Label)
+	def := p.links[key]
+	if x.Ref == RefNone || key == "" || def == nil || def.URL != x.URL || def.Title != x.Title {
+		return false
+	}
+	p.WriteByte('[')
+	start := p.buf.Len()
+	for _, c := range x.Inner {
+		c.printMarkdown(p)
+	}
+	text := string(p.buf.Bytes()[start:])
+	p.WriteByte(']')
+	ref := x.Ref
+	if ref != RefFull && normalizeLabel(text) != key {
+		ref = RefFull
+	}
+	switch ref {
+	case RefFull:
+		p.WriteString("[" + strings.ReplaceAll(trimSpaceTabNewline(x.Label), "\n", " ") + "]")
+	case RefCollapsed:
+		p.WriteString("[]")
+	}
+	return true
+}
+
 func printLinkTitleMarkdown(p *printer, title string, titleChar byte) {
 	if title == "" {
 		return
@@ -81,9 +169,14 @@ func printLinkTitleMarkdown(p *printer, title string, titleChar byte) {
 	}
 	p.WriteString(" ")
 	p.WriteByte(openChar)
-	for i, line := range strings.Split(mdEscaper.Replace(title), "\n") {
+	title = escapePunct(strings.ReplaceAll(title, `\`, `\\`), p.escapes().Title)
+	if closeChar != ')' {
+		title = strings.ReplaceAll(title, string(closeChar), `\`+string(closeChar))
+	}
+	for i, line := range strings.Split(title, "\n") {
 		if i > 0 {
 			p.nl()
+			line = escapeTitleLine(line)
 		}
 		p.WriteString(line)
 		p.noTrim()
@@ -91,6 +184,29 @@ func printLinkTitleMarkdown(p *printer, title string, titleChar byte) {
 	p.WriteByte(closeChar)
 }
 
+// escapeTitleLine returns line, a continuation line of a
+// multi-line link title, with a backslash inserted if needed
+// to keep the line from starting a new block, such as
+// a heading, list item, setext underline, or code fence,
+// which would end the paragraph containing the link.
+func escapeTitleLine(line string) string {
+	if line == "" {
+		return line
+	}
+	switch line[0] {
+	case '#', '-', '+', '=', '`', '~', '*', '_', '<', '>':
+		return `\` + line
+	}
+	i := 0
+	for i < len(line) && isDigit(line[i]) {
+		i++
+	}
+	if i > 0 && i < len(line) && line[i] == '.' {
+		return line[:i] + `\` + line[i:]
+	}
+	return line
+}
+
 func (x *Link) printText(p *printer) {
 	for _, c := range x.Inner {
 		c.printText(p)
@@ -100,7 +216,13 @@ func (x *Link) printText(p *printer) {
 func (*Image) Inline() {}
 
 func (x *Image) printHTML(p *printer) {
-	p.html(`<img src="`, htmlLinkEscaper.Replace(x.URL), `" alt="`)
+	src := p.url(x.URL)
+	if p.ImageData != nil {
+		if typ, data, ok := p.ImageData(src); ok {
+			src = "data:" + typ + ";base64," + base64.StdEncoding.EncodeToString(data)
+		}
+	}
+	p.html(`<img src="`, htmlLinkEscaper.Replace(src), `" alt="`)
 	i := p.buf.Len()
 	x.printText(p)
 	// GitHub and Goldmark both rewrite \n to space
@@ -119,12 +241,34 @@ func (x *Image) printHTML(p *printer) {
 		p.text(x.Title)
 		p.html(`"`)
 	}
+	if x.Width != "" {
+		p.html(` width="`)
+		p.text(x.Width)
+		p.html(`"`)
+	}
+	if x.Height != "" {
+		p.html(` height="`)
+		p.text(x.Height)
+		p.html(`"`)
+	}
+	if p.ImageLazyLoading {
+		p.html(` loading="lazy"`)
+	}
+	if p.ImageAsyncDecoding {
+		p.html(` decoding="async"`)
+	}
 	p.html(` />`)
 }
 
 func (x *Image) printMarkdown(p *printer) {
+	defer func(old bool) { p.sentences = old }(p.sentences)
+	p.sentences = false // leave image text intact
+	var size string
+	if x.Width != "" || x.Height != "" {
+		size = "=" + x.Width + "x" + x.Height
+	}
 	p.WriteString("!")
-	(*Link)(x).printMarkdown(p)
+	printLinkMarkdown(p, &Link{Inner: x.Inner, URL: x.URL, Title: x.Title, TitleChar: x.TitleChar, Label: x.Label, Ref: x.Ref}, size)
 }
 
 func (x *Image) printText(p *printer) {
@@ -153,27 +297,48 @@ func parseImageOpen(_ *parser, s string, start int) (x Inline, end int, ok bool)
 	return
 }
 
+// unresolvedLinkEnd reports whether s[start:] begins with the
+// close of a full or collapsed reference link, ][label] or ][],
+// and if so returns the end of the reference.
+// It is called after parseLinkClose fails, to apply [Parser.UnresolvedLinks].
+func unresolvedLinkEnd(p *parser, s string, start int) (end int, ok bool) {
+	if strings.HasPrefix(s[start+1:], "[]") {
+		return start + 3, true
+	}
+	if _, end, ok := parseLinkLabel(p, s, start+1); ok {
+		return end, true
+	}
+	return 0, false
+}
+
 // parseLinkClose parses a link (or image) close ] or ](target) matching open.
-func parseLinkClose(p *parser, s string, start int, open *openPlain) (*Link, int, bool) {
+func parseLinkClose(p *parser, s string, start int, open *openPlain) (Inline, int, bool) {
 	i := start
 	if i+1 < len(s) {
 		switch s[i+1] {
 		case '(':
 			// Inline link - [Text](Dest Title), with Title omitted or both Dest and Title omitted.
 			i := skipSpace(s, i+2)
-			var dest, title string
+			var dest, title, width, height string
 			var titleChar byte
 			if i < len(s) && s[i] != ')' {
 				var ok bool
-				dest, i, ok = parseLinkDest(s, i)
+				dest, i, ok = parseLinkDest(p, s, i)
 				if !ok {
 					break
 				}
-				i = skipSpace(s, i)
+				j := skipSpace(s, i)
+				if p.ImageSize && open.Text[0] == '!' && j > i {
+					if w, h, end, ok := parseImageSize(s, j); ok {
+						width, height = w, h
+						j = end
+					}
+				}
+				i = skipSpace(s, j)
 				if i < len(s) && s[i] != ')' {
-					title, titleChar, i, ok = parseLinkTitle(s, i)
+					title, titleChar, i, ok = parseLinkTitle(p, s, i)
 					if title == "" {
-						p.corner = true
+						p.noteCorner("link empty title")
 					}
 					if !ok {
 						break
@@ -182,7 +347,7 @@ func parseLinkClose(p *parser, s string, start int, open *openPlain) (*Link, int
 				}
 			}
 			if i < len(s) && s[i] == ')' {
-				return &Link{URL: dest, Title: title, TitleChar: titleChar}, i + 1, true
+				return linkOrImage(open, &Link{URL: dest, Title: title, TitleChar: titleChar}, width, height), i + 1, true
 			}
 			// NOTE: Test malformed ( ) with shortcut reference
 			// TODO fall back on syntax error?
@@ -193,8 +358,10 @@ func parseLinkClose(p *parser, s string, start int, open *openPlain) (*Link, int
 			if !ok {
 				break
 			}
-			if link, ok := p.links[normalizeLabel(label)]; ok {
-				return &Link{URL: link.URL, Title: link.Title}, i, true
+			key := normalizeLabel(label)
+			if link, ok := p.links[key]; ok {
+				p.useLink(key)
+				return linkOrImage(open, &Link{URL: link.URL, Title: link.Title, Label: label, Ref: RefFull}, "", ""), i, true
 			}
 			// Note: Could break here, but CommonMark dingus does not
 			// fall back to trying Text for [Text][Label] when Label is unknown.
@@ -205,16 +372,31 @@ func parseLinkClose(p *parser, s string, start int, open *openPlain) (*Link, int
 
 	// Collapsed or shortcut reference link: [Text][] or [Text].
 	end := i + 1
+	ref := RefShortcut
 	if strings.HasPrefix(s[end:], "[]") {
 		end += 2
+		ref = RefCollapsed
 	}
 
-	if link, ok := p.links[normalizeLabel(s[open.i:i])]; ok {
-		return &Link{URL: link.URL, Title: link.Title}, end, true
+	label := s[open.i:i]
+	key := normalizeLabel(label)
+	if link, ok := p.links[key]; ok {
+		p.useLink(key)
+		return linkOrImage(open, &Link{URL: link.URL, Title: link.Title, Label: label, Ref: ref}, "", ""), end, true
 	}
 	return nil, 0, false
 }
 
+// linkOrImage returns l if open is a link opener [.
+// If open is an image opener ![, it returns an [Image]
+// with l's destination and reference and the given size.
+func linkOrImage(open *openPlain, l *Link, width, height string) Inline {
+	if open.Text[0] != '!' {
+		return l
+	}
+	return &Image{URL: l.URL, Title: l.Title, TitleChar: l.TitleChar, Label: l.Label, Ref: l.Ref, Width: width, Height: height}
+}
+
 // printLinks prints the links in the map, sorted by key,
 // as a sequence of [link reference definitions].
 //
@@ -234,7 +416,11 @@ func printLinks(p *printer, links map[string]*Link) {
 		if u == "" || strings.ContainsAny(u, " ") {
 			u = "<" + u + ">"
 		}
-		fmt.Fprintf(p, "[%s]: %s", k, u)
+		label := k
+		if l.Label != "" && normalizeLabel(l.Label) == k {
+			label = strings.ReplaceAll(trimSpaceTabNewline(l.Label), "\n", " ")
+		}
+		fmt.Fprintf(p, "[%s]: %s", label, u)
 		printLinkTitleMarkdown(p, l.Title, l.TitleChar)
 		p.nl()
 	}
@@ -263,11 +449,11 @@ func parseLinkRefDef(p *parser, s string) (int, bool) {
 	}
 	i = skipSpace(s, i+1)
 	suf := s[i:]
-	dest, i, ok := parseLinkDest(s, i)
+	dest, i, ok := parseLinkDest(p, s, i)
 	if !ok {
 		if suf != "" && suf[0] == '<' {
 			// Goldmark treats <<> as a link definition.
-			p.corner = true
+			p.noteCorner("link definition bad <destination>")
 		}
 		return 0, false
 	}
@@ -292,7 +478,7 @@ func parseLinkRefDef(p *parser, s string) (int, bool) {
 		for j < len(s) && (s[j] == ' ' || s[j] == '\t') {
 			j++
 		}
-		if t, c, j, ok := parseLinkTitle(s, j); ok {
+		if t, c, j, ok := parseLinkTitle(p, s, j); ok {
 			for j < len(s) && (s[j] == ' ' || s[j] == '\t') {
 				j++
 			}
@@ -301,7 +487,7 @@ func parseLinkRefDef(p *parser, s string) (int, bool) {
 				if t == "" {
 					// Goldmark adds title="" in this case.
 					// We do not, nor does the Dingus.
-					p.corner = true
+					p.noteCorner("link definition empty title")
 				}
 				title = t
 				titleChar = c
@@ -317,20 +503,49 @@ func parseLinkRefDef(p *parser, s string) (int, bool) {
 		i++
 	}
 
-	label = normalizeLabel(label)
-	if p.link(label) == nil {
-		p.defineLink(label, &Link{URL: dest, Title: title, TitleChar: titleChar})
+	key := normalizeLabel(label)
+	if p.link(key) == nil {
+		p.defineLink(key, &Link{URL: dest, Title: title, TitleChar: titleChar, Label: label})
 	}
 	return i, true
 }
 
+// parseImageSize parses an image size =WxH at s[i:],
+// as enabled by [Parser.ImageSize], returning the width, the height,
+// the index just past the size, and whether a size was found at all.
+// W and H are decimal numbers; either one can be omitted, but not both.
+// The size must be followed by a space, tab, newline, or closing parenthesis.
+func parseImageSize(s string, i int) (width, height string, end int, found bool) {
+	if i >= len(s) || s[i] != '=' {
+		return
+	}
+	j := i + 1
+	for j < len(s) && isDigit(s[j]) {
+		j++
+	}
+	w := s[i+1 : j]
+	if j >= len(s) || s[j] != 'x' {
+		return
+	}
+	j++
+	k := j
+	for k < len(s) && isDigit(s[k]) {
+		k++
+	}
+	h := s[j:k]
+	if w == "" && h == "" || k >= len(s) || s[k] != ' ' && s[k] != '\t' && s[k] != '\n' && s[k] != ')' {
+		return
+	}
+	return w, h, k, true
+}
+
 // parseLinkTitle parses a [link title] at s[i:], returning
 // the terminating character, one of " ' or );
 // the index just past the end of the link;
 // and whether a link was found at all.
 //
 // [link title]: https://spec.commonmark.org/0.31.2/#link-title
-func parseLinkTitle(s string, i int) (title string, char byte, end int, found bool) {
+func parseLinkTitle(p *parser, s string, i int) (title string, char byte, end int, found bool) {
 	if i < len(s) && (s[i] == '"' || s[i] == '\'' || s[i] == '(') {
 		want := s[i]
 		if want == '(' {
@@ -341,7 +556,7 @@ func parseLinkTitle(s string, i int) (title string, char byte, end int, found bo
 			if s[j] == want {
 				title := s[i+1 : j]
 				// TODO: Validate title?
-				return mdUnescaper.Replace(title), want, j + 1, true
+				return p.unescape(title), want, j + 1, true
 			}
 			if s[j] == '(' && want == ')' {
 				break
@@ -375,7 +590,7 @@ func parseLinkLabel(p *parser, s string, i int) (string, int, bool) {
 		if s[j] == ']' {
 			if j-(i+1) > 999 {
 				// Goldmark does not apply 999 limit.
-				p.corner = true
+				p.noteCorner("link label too long")
 				break
 			}
 			if label := trimSpaceTabNewline(s[i+1 : j]); label != "" {
@@ -394,14 +609,35 @@ func parseLinkLabel(p *parser, s string, i int) (string, int, bool) {
 	return "", 0, false
 }
 
+// NormalizeLinkLabel returns the normalized form of the link label s,
+// which is the key the parser uses for s in [Document.Links].
+// Two labels match, as in a reference link and a link reference definition,
+// exactly when their normalized forms are equal.
+// The label s is written without its enclosing brackets,
+// as in [Link.Label]. Backslash escapes are not processed,
+// so \* and * are different labels, as the CommonMark specification requires.
+// Because a label cannot contain unescaped brackets, NormalizeLinkLabel
+// returns the empty string, which is never a valid key, if s contains
+// a [ or ] that is not preceded by a backslash.
+//
+// See https://spec.commonmark.org/0.31.2/#matches.
+func NormalizeLinkLabel(s string) string {
+	return normalizeLabel(s)
+}
+
 // normalizeLabel returns the normalized label for s, for uniquely identifying that label.
 func normalizeLabel(s string) string {
-	if strings.Contains(s, "[") || strings.Contains(s, "]") {
-		// Labels cannot have [ ] so avoid the work of translating.
-		// This is especially important for pathlogical cases like
-		// [[[[[[[[[[a]]]]]]]]]] which would otherwise generate quadratic
-		// amounts of garbage.
-		return ""
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[', ']':
+			// Labels cannot have unescaped [ ] so avoid the work of translating.
+			// This is especially important for pathlogical cases like
+			// [[[[[[[[[[a]]]]]]]]]] which would otherwise generate quadratic
+			// amounts of garbage.
+			return ""
+		}
 	}
 
 	// “To normalize a label, strip off the opening and closing brackets,
@@ -438,9 +674,8 @@ func normalizeLabel(s string) string {
 		// Also, https://www.unicode.org/faq/casemap_charprop.html#2 says
 		// “case-folded text should be used solely for internal processing and
 		// generally should not be stored or displayed to the end user.”
-		// But we use this string as the map key in p.links and then
-		// display it in printLinks.
-		// We should probably record the actual label separate from the folded one.
+		// We use this string only as the map key in p.links;
+		// printLinks displays the original label saved in Link.Label.
 		// Table at https://www.unicode.org/Public/12.1.0/ucd/CaseFolding.txt.
 		s = cases.Fold().String(s)
 	}
@@ -452,7 +687,7 @@ func normalizeLabel(s string) string {
 // and whether a destination was found.
 //
 // [link destination]: https://spec.commonmark.org/0.31.2/#link-destination
-func parseLinkDest(s string, i int) (string, int, bool) {
+func parseLinkDest(p *parser, s string, i int) (string, int, bool) {
 	if i >= len(s) {
 		return "", 0, false
 	}
@@ -466,7 +701,7 @@ func parseLinkDest(s string, i int) (string, int, bool) {
 			}
 			if s[j] == '>' {
 				// TODO unescape?
-				return mdUnescape(s[i+1 : j]), j + 1, true
+				return p.unescape(s[i+1 : j]), j + 1, true
 			}
 			if s[j] == '\\' {
 				j++
@@ -478,6 +713,10 @@ func parseLinkDest(s string, i int) (string, int, bool) {
 	// does not include ASCII control characters or space character,
 	// and includes parentheses only if (a) they are backslash-escaped
 	// or (b) they are part of a balanced pair of unescaped parentheses.
+	maxDepth := p.MaxLinkParenDepth
+	if maxDepth <= 0 {
+		maxDepth = 32 // same as cmark-gfm
+	}
 	depth := 0
 	j := i
 Loop:
@@ -485,9 +724,8 @@ Loop:
 		switch s[j] {
 		case '(':
 			depth++
-			if depth > 32 {
+			if depth > maxDepth {
 				// Avoid quadratic inputs by stopping if too deep.
-				// This is the same depth that cmark-gfm uses.
 				return "", 0, false
 			}
 		case ')':
@@ -511,7 +749,7 @@ Loop:
 	// TODO: Validate dest?
 	// TODO: Unescape?
 	// NOTE: CommonMark Dingus does not reject control characters.
-	return mdUnescape(dest), j, true
+	return p.unescape(dest), j, true
 }
 
 // An AutoLink is an [Inline] representing an [autolink],
@@ -519,14 +757,15 @@ Loop:
 //
 // [autolink]: https://spec.commonmark.org/0.31.2/#autolinks
 type AutoLink struct {
-	Text string
-	URL  string
+	Position // set only when [Parser.InlinePositions] is enabled
+	Text     string
+	URL      string
 }
 
 func (*AutoLink) Inline() {}
 
 func (x *AutoLink) printHTML(p *printer) {
-	p.html(`<a href="`, htmlLinkEscaper.Replace(x.URL), `">`)
+	p.html(`<a href="`, htmlLinkEscaper.Replace(p.url(x.URL)), `">`)
 	p.text(x.Text)
 	p.html(`</a>`)
 }
@@ -574,7 +813,7 @@ func parseAutoLinkURI(s string, i int) (x Inline, end int, ok bool) {
 	}
 	link := s[i+1 : j]
 	// link = mdUnescaper.Replace(link)
-	return &AutoLink{link, link}, j + 1, true
+	return &AutoLink{Text: link, URL: link}, j + 1, true
 }
 
 // parseAutoLinkEmail is an [inlineParser] for an email [AutoLink].
@@ -613,7 +852,7 @@ func parseAutoLinkEmail(s string, i int) (x Inline, end int, ok bool) {
 		}
 	}
 	email := s[i+1 : j]
-	return &AutoLink{email, "mailto:" + email}, j + 1, true
+	return &AutoLink{Text: email, URL: "mailto:" + email}, j + 1, true
 }
 
 // skipDomainElem reports the length of a leading domain element in s,
@@ -667,8 +906,10 @@ func isURL(c byte) bool {
 // autoLinkText rewrites any extended autolinks in the body
 // and returns the result.
 //
-// list is a list of Plain, Emph, Strong, and Del nodes.
-// There are no Link nodes.
+// It rewrites Plain nodes and descends into Emph, Strong, and Del nodes.
+// It does not descend into Link or Image nodes, so that text already
+// inside a link or image is never linked a second time.
+// See testdata/autolink_nested.txt.
 //
 // The GitHub “spec” declares that “autolinks can only come at the
 // beginning of a line, after whitespace, or any of the delimiting
@@ -724,10 +965,14 @@ Restart:
 	for i := 0; i < len(s); i++ {
 		c := s[i]
 		if c == '@' {
-			if before, link, after, ok := parseAutoEmail(p, s, i); ok {
+			if before, link, after, ok := parseAutoEmail(p, s, i); ok && (!p.AutoLinkStrictEmail || isEmailBoundary(before, after)) {
 				if before != "" {
 					out = append(out, &Plain{Text: before})
 				}
+				link.Bare = true
+				if p.OnLink != nil {
+					p.OnLink(link)
+				}
 				out = append(out, link)
 				vd.removePrefix(len(s) - len(after))
 				s = after
@@ -741,6 +986,10 @@ Restart:
 				if i > 0 {
 					out = append(out, &Plain{Text: s[:i]})
 				}
+				link.Bare = true
+				if p.OnLink != nil {
+					p.OnLink(link)
+				}
 				out = append(out, link)
 				vd.removePrefix(len(s) - len(after))
 				s = after
@@ -755,6 +1004,38 @@ Restart:
 	return out
 }
 
+// isEmailBoundary reports whether an email address
+// between the texts before and after is at word boundaries,
+// as required by [Parser.AutoLinkStrictEmail].
+func isEmailBoundary(before, after string) bool {
+	if before != "" {
+		if r, _ := utf8.DecodeLastRuneInString(before); !isEmailBoundaryRune(r) {
+			return false
+		}
+	}
+	if after != "" {
+		if r, _ := utf8.DecodeRuneInString(after); !isEmailBoundaryRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// emailWordChars are the punctuation characters that do not end
+// a word containing an email address, because they can appear in
+// addresses (RFC 5322 allows them in the local part) or commonly
+// join an address to surrounding text, as in x=abc@example.com
+// or abc@example.com/path.
+const emailWordChars = "#$%&+-/=@\\^`{|}"
+
+// isEmailBoundaryRune reports whether r can separate
+// an email address from the surrounding text:
+// a Unicode space or punctuation character
+// other than those in emailWordChars.
+func isEmailBoundaryRune(r rune) bool {
+	return isUnicodeSpace(r) || isUnicodePunct(r) && !strings.ContainsRune(emailWordChars, r)
+}
+
 // parseAutoURL parses an [extended URL autolink] or [extended www autolink],
 // or [extended protocol autolink] from s[i:] if one exists,
 // using vd as its valid domain checker.
@@ -842,11 +1123,18 @@ func parseAutoHTTP(p *parser, scheme, s string, textstart, start, min int, vd *v
 Trim:
 	for i > 0 {
 		switch s[i-1] {
-		case '?', '!', '.', ',', ':', '@', '_', '~':
+		case '?', '!', '.', ',', ':':
 			// Trim certain trailing punctuation.
 			i--
 			continue Trim
 
+		case '@', '_', '~':
+			// Also trimmed by GitHub but not in minimal mode.
+			if !p.AutoLinkMinimalTrim {
+				i--
+				continue Trim
+			}
+
 		case ')':
 			// Trim trailing unmatched (by count only) parens.
 			if paren < 0 {
@@ -898,6 +1186,12 @@ Trim:
 		return
 	}
 
+	if textstart == start && p.AutoLinkScheme != nil {
+		if sch := p.AutoLinkScheme(s[start:domEnd]); sch != "" {
+			scheme = sch + "://"
+		}
+	}
+
 	link = &Link{
 		Inner: []Inline{&Plain{Text: s[textstart:i]}},
 		URL:   scheme + s[start:i],
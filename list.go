@@ -7,6 +7,7 @@ package markdown
 import (
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 // TODO should Item implement Block?
@@ -62,23 +63,46 @@ type Item struct {
 
 	// Blocks is the item content.
 	Blocks []Block
+
+	// Indent is the number of spaces before the item's marker in the input,
+	// and Width is the width of the item's leading indentation, marker,
+	// and following spaces, which is the indentation required for
+	// continuation lines in the item.
+	// [Printer.Format] uses them when [Printer.MinimalDiff] is set.
+	Indent int
+	Width  int
 }
 
 func (*Item) Block() {}
 
 func (b *List) printHTML(p *printer) {
+	defer func(old ListSpacing) {
+		p.spacing = old
+	}(p.spacing)
+	switch loose := p.listLoose(b); {
+	case loose == b.Loose:
+		p.spacing = ListSpacingAuto
+	case loose:
+		p.spacing = ListSpacingLoose
+	default:
+		p.spacing = ListSpacingTight
+	}
+	p.indentHTML()
 	if b.Bullet == '.' || b.Bullet == ')' {
-		p.html("<ol")
+		p.html("<ol", p.class("ol"))
 		if b.Start != 1 {
 			p.html(` start="`, strconv.Itoa(b.Start), `"`)
 		}
 		p.html(">\n")
 	} else {
-		p.html("<ul>\n")
+		p.html("<ul", p.class("ul"), ">\n")
 	}
+	p.depth++
 	for _, item := range b.Items {
 		item.printHTML(p)
 	}
+	p.depth--
+	p.indentHTML()
 	if b.Bullet == '.' || b.Bullet == ')' {
 		p.html("</ol>\n")
 	} else {
@@ -87,23 +111,85 @@ func (b *List) printHTML(p *printer) {
 }
 
 func (b *Item) printHTML(p *printer) {
-	p.html("<li>")
-	if len(b.Blocks) > 0 {
-		if _, ok := b.Blocks[0].(*Text); !ok {
+	p.indentHTML()
+	p.html("<li", p.class("li"), ">")
+	blocks := b.Blocks
+	if p.spacing != ListSpacingAuto || p.AlwaysWrapListParagraphs {
+		blocks = make([]Block, len(b.Blocks))
+		for i, c := range b.Blocks {
+			blocks[i] = p.itemBlock(c)
+		}
+	}
+	if len(blocks) > 0 {
+		if _, ok := blocks[0].(*Text); !ok {
 			p.WriteString("\n")
 		}
 	}
-	for i, c := range b.Blocks {
+	p.depth++
+	for i, c := range blocks {
 		c.printHTML(p)
-		if i+1 < len(b.Blocks) {
+		if i+1 < len(blocks) {
 			if _, ok := c.(*Text); ok {
 				p.WriteString("\n")
 			}
 		}
 	}
+	p.depth--
+	p.indentHTML()
 	p.html("</li>\n")
 }
 
+// listLoose reports whether b should be printed as a loose list,
+// according to p.ListSpacing.
+func (p *printer) listLoose(b *List) bool {
+	switch p.ListSpacing {
+	case ListSpacingLoose:
+		return true
+	case ListSpacingTight:
+		return b.Loose && !canBeTight(b)
+	}
+	return b.Loose
+}
+
+// canBeTight reports whether b can be printed as a tight list:
+// each item must contain at most one paragraph, as its first block,
+// and otherwise only nested lists.
+func canBeTight(b *List) bool {
+	for _, item := range b.Items {
+		for i, c := range item.(*Item).Blocks {
+			switch c.(type) {
+			case *List:
+				continue
+			case *Paragraph, *Text:
+				if i == 0 {
+					continue
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// itemBlock returns the block to print in place of c,
+// a top-level block in an item of a list with overridden spacing
+// or printed with [Printer.AlwaysWrapListParagraphs]:
+// paragraphs in loose lists print in <p> tags,
+// and paragraphs in tight lists do not, unless AlwaysWrapListParagraphs is set.
+func (p *printer) itemBlock(c Block) Block {
+	switch c := c.(type) {
+	case *Text:
+		if p.spacing == ListSpacingLoose || p.AlwaysWrapListParagraphs {
+			return &Paragraph{c.Position, c}
+		}
+	case *Paragraph:
+		if p.spacing == ListSpacingTight && !p.AlwaysWrapListParagraphs {
+			return c.Text
+		}
+	}
+	return c
+}
+
 func (b *List) printMarkdown(p *printer) {
 	old := p.listOut
 	defer func() {
@@ -111,16 +197,29 @@ func (b *List) printMarkdown(p *printer) {
 	}()
 	p.bullet = b.Bullet
 	p.num = b.Start
-	if b.Loose {
+	loose := p.listLoose(b)
+	if d := p.OrderedListDelimiter; (d == '.' || d == ')') && b.Ordered() {
+		if p.lastDelim == d {
+			d = '.' + ')' - d
+		}
+		p.bullet = d
+	}
+	defer func(delim rune) {
+		p.lastDelim = delim
+	}(p.bullet)
+	if loose {
 		p.loose++
 	} else {
+		// Blocks in nested tight items must not be separated
+		// by blank lines, even in a loose list.
+		p.loose = 0
 		p.tight++
 	}
 	p.maybeNL()
 	for i, item := range b.Items {
 		if i > 0 {
 			p.nl()
-			if b.Loose {
+			if loose {
 				p.nl()
 			}
 		}
@@ -130,21 +229,77 @@ func (b *List) printMarkdown(p *printer) {
 }
 
 func (b *Item) printMarkdown(p *printer) {
-	var marker string
+	var marker, indent string
 	if p.bullet == '.' || p.bullet == ')' {
-		marker = fmt.Sprintf(" %d%c ", p.num, p.bullet)
+		marker, indent = fmt.Sprintf("%d%c", p.num, p.bullet), " "
 	} else {
-		marker = fmt.Sprintf("  %c ", p.bullet)
+		marker, indent = string(p.bullet), "  "
 	}
-	p.WriteString(marker)
-	n := len(marker)
-	if n > 4 {
-		n = 4
+
+	var n int
+	switch spaces := p.ListMarkerSpaces; {
+	case spaces == 0 && p.MinimalDiff && b.Width > 0:
+		// Preserve the input's spacing.
+		spaces = b.markerSpaces(p, b.Width-b.Indent-len(marker))
+		marker = strings.Repeat(" ", b.Indent) + marker + strings.Repeat(" ", spaces)
+		n = len(marker)
+	case spaces > 0:
+		spaces = b.markerSpaces(p, spaces)
+		marker = indent + marker + strings.Repeat(" ", spaces)
+		n = len(marker)
+	default:
+		marker = indent + marker + " "
+		n = min(len(marker), 4)
 	}
-	defer p.pop(p.push("    "[:n]))
+	p.WriteString(marker)
+	defer p.pop(p.push(strings.Repeat(" ", n)))
 	printMarkdownBlocks(b.Blocks, p)
 }
 
+// markerSpaces returns the number of spaces to print after
+// the marker of b, given that spaces were requested.
+// Content indented 5 or more columns past the marker
+// would be an indented code block, so markerSpaces counts
+// the leading indentation of a nested list's first marker
+// against that limit. It returns 1 when b starts with
+// an indented code block or a blank line, since then
+// the content indentation is always the marker width plus 1.
+func (b *Item) markerSpaces(p *printer, spaces int) int {
+	spaces = max(spaces, 1)
+	if len(b.Blocks) == 0 {
+		return spaces
+	}
+	switch first := b.Blocks[0].(type) {
+	case *CodeBlock:
+		if first.Fence == "" {
+			return 1
+		}
+	case *List:
+		if spaces+listIndent(p, first) > 4 {
+			return 1
+		}
+	}
+	if b.StartLine > 0 && b.Blocks[0].Pos().StartLine > b.StartLine {
+		return 1
+	}
+	return min(spaces, 4)
+}
+
+// listIndent returns the number of spaces that
+// [Item.printMarkdown] prints before the first marker of list.
+func listIndent(p *printer, list *List) int {
+	if len(list.Items) == 0 {
+		return 0
+	}
+	if item, ok := list.Items[0].(*Item); ok && p.ListMarkerSpaces == 0 && p.MinimalDiff && item.Width > 0 {
+		return item.Indent
+	}
+	if list.Ordered() {
+		return 1
+	}
+	return 2
+}
+
 // A listBuilder is a [blockBuilder] for a [List].
 type listBuilder struct {
 	// List fields
@@ -161,6 +316,7 @@ type listBuilder struct {
 // An itemBuilder is a [blockBuilder] for an [Item].
 type itemBuilder struct {
 	list        *listBuilder //  list containing item
+	indent      int          // spaces before marker
 	width       int          // TODO
 	haveContent bool         // TODO
 }
@@ -183,6 +339,7 @@ func startListItem(p *parser, s line) (_ line, _ bool) {
 		}
 		n++
 	}
+	indent := n
 	bullet := t.peek()
 	var num int
 Switch:
@@ -248,13 +405,13 @@ Switch:
 			// when the paragraph that could be continued
 			// is inside a block quote.
 			// See testdata/extra.txt 117.md.
-			p.corner = true
+			p.noteCorner("list item cannot interrupt paragraph")
 			return
 		}
 		list = &listBuilder{bullet: rune(bullet), start: num}
 		p.addBlock(list)
 	}
-	b := &itemBuilder{list: list, width: n, haveContent: !t.isBlank()}
+	b := &itemBuilder{list: list, indent: indent, width: n, haveContent: !t.isBlank()}
 	list.todo = func() line {
 		p.addBlock(b)
 		list.item = b
@@ -306,7 +463,7 @@ func (c *itemBuilder) extend(p *parser, s line) (line, bool) {
 
 func (b *itemBuilder) build(p *parser) Block {
 	b.list.item = nil
-	return &Item{p.pos(), p.blocks()}
+	return &Item{p.pos(), p.blocks(), b.indent, b.width}
 }
 
 func (b *listBuilder) build(p *parser) Block {
@@ -331,7 +488,11 @@ Loose:
 		for j, d := range c.Blocks {
 			endLine := d.Pos().EndLine
 			if j+1 < len(c.Blocks) {
-				if c.Blocks[j+1].Pos().StartLine-endLine > 1 {
+				gap := c.Blocks[j+1].Pos().StartLine - endLine
+				if gap == 2 && p.TightListBlanks && cosmeticBlank(d, c.Blocks[j+1]) {
+					continue
+				}
+				if gap > 1 {
 					loose = true
 					break Loose
 				}
@@ -370,20 +531,37 @@ Loose:
 	return x
 }
 
+// cosmeticBlank reports whether a blank line between
+// the blocks x and y in a list item is only cosmetic,
+// meaning that y would still start a new block
+// if the blank line were removed (see [Parser.TightListBlanks]).
+func cosmeticBlank(x, y Block) bool {
+	if _, ok := x.(*HTMLBlock); ok {
+		return false
+	}
+	switch y := y.(type) {
+	case *List, *Quote:
+		return true
+	case *CodeBlock:
+		return y.Fence != ""
+	}
+	return false
+}
+
 // listCorner checks whether list contains any corner cases
-// that other implementations mishandle, and if so sets p.corner.
+// that other implementations mishandle, and if so records them in p.
 func listCorner(p *parser, list *List) {
 	for _, item := range list.Items {
 		item := item.(*Item)
 		if len(item.Blocks) == 0 {
 			// Goldmark mishandles what follows; see testdata/extra.txt 111.md.
-			p.corner = true
+			p.cornerAt(item.StartLine, "empty list item")
 			return
 		}
 		switch item.Blocks[0].(type) {
 		case *List, *ThematicBreak, *CodeBlock:
 			// Goldmark mishandles a list with various block items inside it.
-			p.corner = true
+			p.cornerAt(item.StartLine, "list item starting with block")
 			return
 		}
 	}
@@ -406,6 +584,13 @@ func (x *Task) printHTML(p *printer) {
 	if x.Checked {
 		p.html(`checked="" `)
 	}
+	if p.ARIA {
+		if x.Checked {
+			p.html(`aria-label="Completed task" `)
+		} else {
+			p.html(`aria-label="Incomplete task" `)
+		}
+	}
 	p.html(`disabled="" type="checkbox"> `)
 }
 
@@ -426,6 +611,12 @@ func (x *Task) printText(p *printer) {
 	x.printMarkdown(p)
 }
 
+// isTaskMarker reports whether s begins with a task list marker,
+// [ ] or [x] or [X], followed by at least one more byte.
+func isTaskMarker(s string) bool {
+	return len(s) >= 4 && s[0] == '[' && s[2] == ']' && (s[1] == ' ' || s[1] == 'x' || s[1] == 'X')
+}
+
 // taskList checks whether any items in list begin with task list markers.
 // If so, it replaces the markers with [Task]s.
 func parseTaskList(p *parser, list *List) {
@@ -443,6 +634,20 @@ func parseTaskList(p *parser, list *List) {
 		case *Text:
 			text = b
 		}
+		if text.Raw != "" {
+			// Inline parsing is deferred (Parser.DeferInline).
+			// Check the raw text instead, unless the marker
+			// is a link, as in [x] with a definition for x,
+			// or the text was demoted from a heading and so
+			// will be wrapped in a Strong (HeadingOverflowStrong).
+			s := text.Raw
+			if len(text.Inline) > 0 || !isTaskMarker(s) || s[3] != ' ' && s[3] != '\t' || s[1] != ' ' && p.links[normalizeLabel(s[1:2])] != nil {
+				continue
+			}
+			text.Inline = []Inline{&Task{Checked: s[1] == 'x' || s[1] == 'X'}}
+			text.Raw = s[len("[x] "):]
+			continue
+		}
 		if len(text.Inline) < 1 {
 			// unreachable with standard parser
 			continue
@@ -452,11 +657,11 @@ func parseTaskList(p *parser, list *List) {
 			continue
 		}
 		s := pl.Text
-		if len(s) < 4 || s[0] != '[' || s[2] != ']' || (s[1] != ' ' && s[1] != 'x' && s[1] != 'X') {
+		if !isTaskMarker(s) {
 			continue
 		}
 		if s[3] != ' ' && s[3] != '\t' {
-			p.corner = true // goldmark does not require the space
+			p.cornerAt(item.StartLine, "task marker without space") // goldmark does not require the space
 			continue
 		}
 		text.Inline = append([]Inline{&Task{Checked: s[1] == 'x' || s[1] == 'X'},
@@ -6,56 +6,62 @@
 //
 // Usage:
 //
-//	md2html [file...]
+//	md2html [-full] [-css url] [file...]
 //
 // Md2html reads the named files, or else standard input, as Markdown documents
 // and then prints the corresponding HTML to standard output.
+//
+// By default, md2html prints only the HTML for the document content.
+// The -full flag causes md2html to print a complete HTML document instead,
+// with a <head> that sets the title to the text of the first heading,
+// or else to the file name. The -css flag, which implies -full,
+// adds a link to the given CSS style sheet.
 package main
 
 import (
-	"bytes"
 	"flag"
 	"io/ioutil"
 	"log"
 	"os"
-	"unicode/utf8"
+	"path/filepath"
 
 	"rsc.io/markdown"
 )
 
+var (
+	full = flag.Bool("full", false, "print a complete HTML document")
+	css  = flag.String("css", "", "link the CSS style sheet at `url` (implies -full)")
+)
+
 func main() {
 	flag.Parse()
+	if *css != "" {
+		*full = true
+	}
 	args := flag.Args()
 	if len(args) == 0 {
-		do(os.Stdin)
+		do(os.Stdin, "")
 	} else {
 		for _, arg := range args {
 			f, err := os.Open(arg)
 			if err != nil {
 				log.Fatal(err)
 			}
-			do(f)
+			do(f, filepath.Base(arg))
 			f.Close()
 		}
 	}
 }
 
-func do(f *os.File) {
+func do(f *os.File, name string) {
 	data, err := ioutil.ReadAll(f)
 	if err != nil {
 		log.Fatal(err)
 	}
-	os.Stdout.WriteString(toHTML(data))
+	os.Stdout.WriteString(toHTML(data, name))
 }
 
 // toHTML converts Markdown to HTML.
-func toHTML(md []byte) string {
-	var p markdown.Parser
-	p.Table = true
-	return markdown.ToHTML(p.Parse(string(replaceTabs(md))))
-}
-
-// replaceTabs replaces all tabs in text with spaces up to a 4-space tab stop.
 //
 // In Markdown, tabs used for indentation are required to be interpreted as
 // 4-space tab stops. See https://spec.commonmark.org/0.30/#tabs.
@@ -64,31 +70,15 @@ func toHTML(md []byte) string {
 // Make the Go code consistently compact across browsers,
 // all while staying Markdown-compatible, by expanding to 4-space tab stops.
 //
-// This function does not handle multi-codepoint Unicode sequences correctly.
-func replaceTabs(text []byte) []byte {
-	var buf bytes.Buffer
-	col := 0
-	for len(text) > 0 {
-		r, size := utf8.DecodeRune(text)
-		text = text[size:]
-
-		switch r {
-		case '\n':
-			buf.WriteByte('\n')
-			col = 0
-
-		case '\t':
-			buf.WriteByte(' ')
-			col++
-			for col%4 != 0 {
-				buf.WriteByte(' ')
-				col++
-			}
-
-		default:
-			buf.WriteRune(r)
-			col++
-		}
+// If the -full flag is set, toHTML returns a complete HTML document,
+// using name as the title if the document has no heading.
+func toHTML(md []byte, name string) string {
+	var p markdown.Parser
+	p.Table = true
+	p.ExpandTabs = true
+	doc := p.Parse(string(md))
+	if *full {
+		return markdown.ToHTMLDocument(doc, &markdown.HTMLDocumentOptions{Title: name, StyleSheet: *css})
 	}
-	return buf.Bytes()
+	return markdown.ToHTML(doc)
 }
@@ -7,6 +7,7 @@ package markdown
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"go/token"
@@ -15,6 +16,9 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
 
@@ -43,7 +47,6 @@ var roundTripFailures = map[string]bool{
 	"TestToHTML/spec0.29/57":  true, // setext heading
 	"TestToHTML/spec0.29/63":  true, // setext heading
 	"TestToHTML/spec0.29/65":  true, // newline in heading
-	"TestToHTML/spec0.29/171": true, // link ref def
 	"TestToHTML/spec0.29/208": true, // weird list
 	"TestToHTML/spec0.29/227": true, // weird list
 	"TestToHTML/spec0.29/241": true, // weird list
@@ -55,9 +58,6 @@ var roundTripFailures = map[string]bool{
 	"TestToHTML/spec0.29/325": true, // escape plain
 	"TestToHTML/spec0.29/326": true, // escape plain
 	"TestToHTML/spec0.29/327": true, // escape plain
-	"TestToHTML/spec0.29/331": true, // backtick spaces
-	"TestToHTML/spec0.29/349": true, // backticks
-	"TestToHTML/spec0.29/502": true, // escape quotes
 
 	"TestToHTML/spec0.30/26":  true, // escape plain
 	"TestToHTML/spec0.30/37":  true, // escape plain
@@ -72,15 +72,11 @@ var roundTripFailures = map[string]bool{
 	"TestToHTML/spec0.30/87":  true, // setext heading
 	"TestToHTML/spec0.30/93":  true, // setext heading
 	"TestToHTML/spec0.30/95":  true, // newline in heading
-	"TestToHTML/spec0.30/202": true, // link ref def
 	"TestToHTML/spec0.30/238": true, // weird list
 	"TestToHTML/spec0.30/257": true, // weird list
 	"TestToHTML/spec0.30/271": true, // weird list
 	"TestToHTML/spec0.30/312": true, // weird list
 	"TestToHTML/spec0.30/313": true, // weird list
-	"TestToHTML/spec0.30/331": true, // backtick spaces
-	"TestToHTML/spec0.30/349": true, // backticks
-	"TestToHTML/spec0.30/505": true, // escape quotes
 
 	"TestToHTML/spec0.31.2/26":  true, // escape plain
 	"TestToHTML/spec0.31.2/37":  true, // escape plain
@@ -95,15 +91,11 @@ var roundTripFailures = map[string]bool{
 	"TestToHTML/spec0.31.2/87":  true, // setext heading
 	"TestToHTML/spec0.31.2/93":  true, // setext heading
 	"TestToHTML/spec0.31.2/95":  true, // newline in heading
-	"TestToHTML/spec0.31.2/202": true, // link ref def
 	"TestToHTML/spec0.31.2/238": true, // weird list
 	"TestToHTML/spec0.31.2/257": true, // weird list
 	"TestToHTML/spec0.31.2/271": true, // weird list
 	"TestToHTML/spec0.31.2/312": true, // weird list
 	"TestToHTML/spec0.31.2/313": true, // weird list
-	"TestToHTML/spec0.31.2/331": true, // backtick spaces
-	"TestToHTML/spec0.31.2/349": true, // backticks
-	"TestToHTML/spec0.31.2/506": true, // escape quotes
 
 	"TestToHTML/table/gfm200": true, // table
 	"TestToHTML/table/2":      true, // table
@@ -125,6 +117,7 @@ func TestToHTML(t *testing.T) {
 			}
 
 			var p Parser
+			var pr Printer
 			var ncase, npass int
 			for i := 0; i+2 <= len(a.Files); {
 				if a.Files[i].Name == "parser.json" {
@@ -132,6 +125,11 @@ func TestToHTML(t *testing.T) {
 					i++
 					continue
 				}
+				if a.Files[i].Name == "printer.json" {
+					pr = parsePrinter(t, a.Files[i].Data)
+					i++
+					continue
+				}
 				ncase++
 				md := a.Files[i]
 				html := a.Files[i+1]
@@ -143,7 +141,7 @@ func TestToHTML(t *testing.T) {
 
 				t.Run(name, func(t *testing.T) {
 					doc := p.Parse(decode(string(md.Data)))
-					h := encode(ToHTML(doc))
+					h := encode(pr.ToHTML(doc))
 					if h != string(html.Data) {
 						q := strings.ReplaceAll(url.QueryEscape(decode(string(md.Data))), "+", "%20")
 						t.Fatalf("input %q\nparse:\n%s\nhave %q\nwant %q\ndingus: (https://spec.commonmark.org/dingus/?text=%s)\ngithub: (https://github.com/rsc/tmp/issues/new?body=%s)", md.Data, dump(doc), h, html.Data, q, q)
@@ -155,9 +153,9 @@ func TestToHTML(t *testing.T) {
 					}
 
 					// Make sure Format preserves the HTML.
-					md1 := Format(doc)
+					md1 := pr.Format(doc)
 					doc1 := p.Parse(md1)
-					h1 := encode(ToHTML(doc1))
+					h1 := encode(pr.ToHTML(doc1))
 					if h1 != string(html.Data) && !roundTripFailures[t.Name()] {
 						q := strings.ReplaceAll(url.QueryEscape(decode(string(md.Data))), "+", "%20")
 						t.Fatalf("input %q\nreformat %q\n%s\n%s\nhave %q\nwant %q\ndingus: (https://spec.commonmark.org/dingus/?text=%s)\ngithub: (https://github.com/rsc/tmp/issues/new?body=%s)", md.Data, md1, dump(doc), dump(doc1), h1, html.Data, q, q)
@@ -166,6 +164,15 @@ func TestToHTML(t *testing.T) {
 						t.Fatalf("no longer failing")
 					}
 
+					// Make sure deferred inline parsing gives the same HTML.
+					dp := p
+					dp.DeferInline = true
+					doc2 := dp.Parse(decode(string(md.Data)))
+					parseInlineAll(&dp, doc2, doc2)
+					if h2 := encode(pr.ToHTML(doc2)); h2 != string(html.Data) {
+						t.Fatalf("input %q\nwith DeferInline:\n%s\nhave %q\nwant %q", md.Data, dump(doc2), h2, html.Data)
+					}
+
 					npass++
 				})
 
@@ -173,9 +180,12 @@ func TestToHTML(t *testing.T) {
 					continue
 				}
 				t.Run("goldmark/"+name, func(t *testing.T) {
+					if !reflect.DeepEqual(pr, Printer{}) {
+						t.Skip("printer settings")
+					}
 					in := decode(string(md.Data))
-					_, corner := p.parse(in)
-					if corner {
+					_, corners := p.parse(in)
+					if len(corners) > 0 {
 						t.Skip("known corner case")
 					}
 					gm := goldmarkParser(&p)
@@ -260,6 +270,37 @@ func parseParser(t *testing.T, data []byte) Parser {
 	return p
 }
 
+// TestCmark2txtarParsers checks that the parser configurations
+// written by testdata/cmark2txtar.go are valid parser.json files.
+func TestCmark2txtarParsers(t *testing.T) {
+	data, err := os.ReadFile("testdata/cmark2txtar.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := regexp.MustCompile(`"example[^"]*":\s*`+"`([^`]*)`").FindAllSubmatch(data, -1)
+	if len(list) == 0 {
+		t.Fatal("no parser configurations found")
+	}
+	for _, m := range list {
+		parseParser(t, m[1])
+	}
+}
+
+func parsePrinter(t *testing.T, data []byte) Printer {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	var p Printer
+	err := d.Decode(&p)
+	if err != nil {
+		t.Fatalf("reading printer.json: %v", err)
+	}
+	err = d.Decode(new(json.RawMessage))
+	if err != io.EOF {
+		t.Fatalf("junk on end of printer.json")
+	}
+	return p
+}
+
 func TestFormat(t *testing.T) {
 	files, err := filepath.Glob(filepath.Join("testdata", "*_fmt.txt"))
 	if err != nil {
@@ -272,12 +313,18 @@ func TestFormat(t *testing.T) {
 				t.Fatal(err)
 			}
 			var p Parser
+			var pr Printer
 			for i := 0; i < len(a.Files); {
 				if a.Files[i].Name == "parser.json" {
 					p = parseParser(t, a.Files[i].Data)
 					i++
 					continue
 				}
+				if a.Files[i].Name == "printer.json" {
+					pr = parsePrinter(t, a.Files[i].Data)
+					i++
+					continue
+				}
 				// Each test case is a single markdown document that should render either as itself,
 				// or if followed by a file named "want", then by that file.
 				name := a.Files[i].Name
@@ -295,10 +342,9 @@ func TestFormat(t *testing.T) {
 					if ToHTML(doc) != ToHTML(docWant) {
 						t.Errorf("bad testdata: input and want are different markdown documents:\ninput:\n%s\n\nwant:\n%s", dump(doc), dump(docWant))
 					}
-					h := Format(doc)
-					h = encode(h)
+					h := pr.Format(doc)
 					if h != want {
-						t.Errorf("input %q\nparse: \n%s\nhave %q\nwant %q", in, dump(doc), h, want)
+						t.Errorf("input %q\nparse: \n%s\nhave %q\nwant %q", in, dump(doc), encode(h), encode(want))
 					}
 				})
 			}
@@ -350,6 +396,23 @@ func TestInline(t *testing.T) {
 	new(Task).Inline()
 }
 
+// parseInlineAll calls ParseInline for every Text in b,
+// which is part of doc, including the texts in doc's footnotes.
+func parseInlineAll(p *Parser, doc *Document, b Block) {
+	parse := func(b Block) bool {
+		if t, ok := b.(*Text); ok {
+			t.ParseInline(p, doc)
+		}
+		return true
+	}
+	Walk(b, parse, nil)
+	if b, ok := b.(*Document); ok {
+		for _, note := range b.Notes {
+			walkBlocks(note.Blocks, parse, nil)
+		}
+	}
+}
+
 func findUnexported(v reflect.Value) (reflect.Value, bool) {
 	if t := v.Type(); t.PkgPath() != "" && !token.IsExported(t.Name()) {
 		return v, true
@@ -386,7 +449,7 @@ var (
 	inlinesType = reflect.TypeOf(new(Inlines)).Elem()
 )
 
-func printb(buf *bytes.Buffer, b Block, prefix string) {
+func printb(buf *bytes.Buffer, b any, prefix string) {
 	fmt.Fprintf(buf, "(%T", b)
 	v := reflect.ValueOf(b)
 	v = reflect.Indirect(v)
@@ -441,7 +504,7 @@ func printslice(buf *bytes.Buffer, v reflect.Value, prefix string) {
 	}
 	for i := 0; i < v.Len(); i++ {
 		fmt.Fprintf(buf, " ")
-		printb(buf, v.Index(i).Interface().(Block), prefix+"\t")
+		printb(buf, v.Index(i).Interface(), prefix+"\t")
 	}
 }
 
@@ -475,3 +538,1264 @@ func dump(b Block) string {
 	printb(&buf, b, "")
 	return buf.String()
 }
+
+func TestParseCorners(t *testing.T) {
+	in := "# Title\n\n~~~ go\ncode\n~~~\n\nsome ~one~ and ~three~ tildes\n\n-\n- item\n"
+	p := Parser{Strikethrough: true}
+	_, corners := p.ParseCorners(in)
+	want := []Corner{
+		{3, "info string after ~~~ fence"},
+		{7, "strikethrough not ~~"},
+		{9, "empty list item"},
+	}
+	if !reflect.DeepEqual(corners, want) {
+		t.Errorf("ParseCorners:\nhave %v\nwant %v", corners, want)
+	}
+
+	_, corners = p.ParseCorners("# Title\n\nplain text\n")
+	if len(corners) != 0 {
+		t.Errorf("ParseCorners(plain) = %v, want none", corners)
+	}
+
+	_, corners = p.ParseCorners("    a\r\n    b\r\n\n```\nc\r\n```\n")
+	want = []Corner{
+		{1, "indented code line ending not \\n"},
+		{2, "indented code line ending not \\n"},
+		{5, "fenced code line ending not \\n"},
+	}
+	if !reflect.DeepEqual(corners, want) {
+		t.Errorf("ParseCorners(\\r\\n code):\nhave %v\nwant %v", corners, want)
+	}
+}
+
+func TestUnresolvedLinksCorner(t *testing.T) {
+	in := "# Title\n\nsee [text][missing]\nand [ok][def]\nand [x][]\n\n[def]: /url\n"
+	p := Parser{UnresolvedLinks: UnresolvedLinksText}
+	_, corners := p.ParseCorners(in)
+	want := []Corner{{3, "unresolved link reference"}, {5, "unresolved link reference"}}
+	if !reflect.DeepEqual(corners, want) {
+		t.Errorf("ParseCorners:\nhave %v\nwant %v", corners, want)
+	}
+
+	p.UnresolvedLinks = UnresolvedLinksLiteral
+	if _, corners := p.ParseCorners(in); len(corners) != 0 {
+		t.Errorf("ParseCorners(literal) = %v, want none", corners)
+	}
+}
+
+func TestRenderRange(t *testing.T) {
+	in := "# One\n\nSee [link] and note[^a].\n\n# Two\n\nAnother[^b].\n\n[link]: /url\n[^a]: Note A.\n[^b]: Note B.\n"
+	p := Parser{Footnote: true}
+	doc := p.Parse(in)
+	if len(doc.Blocks) != 4 {
+		t.Fatalf("parsed %d blocks, want 4:\n%s", len(doc.Blocks), dump(doc))
+	}
+
+	have := RenderRange(doc, 0, 2)
+	want := `<h1>One</h1>
+<p>See <a href="/url">link</a> and note<sup class="fn"><a id="fnref-1" href="#fn-1">1</a></sup>.</p>
+<div class="footnotes">Footnotes</div>
+<ol>
+<li id="fn-1">
+<p>Note A.
+<a class="fnref" href="#fnref-1">↩</a></p>
+</li>
+</ol>
+`
+	if have != want {
+		t.Errorf("RenderRange(0, 2):\nhave %q\nwant %q", have, want)
+	}
+
+	have = RenderRange(doc, 2, 4)
+	want = `<h1>Two</h1>
+<p>Another<sup class="fn"><a id="fnref-1" href="#fn-1">1</a></sup>.</p>
+<div class="footnotes">Footnotes</div>
+<ol>
+<li id="fn-1">
+<p>Note B.
+<a class="fnref" href="#fnref-1">↩</a></p>
+</li>
+</ol>
+`
+	if have != want {
+		t.Errorf("RenderRange(2, 4):\nhave %q\nwant %q", have, want)
+	}
+
+	if have, want := RenderRange(doc, 0, len(doc.Blocks)), ToHTML(doc); have != want {
+		t.Errorf("RenderRange(all) != ToHTML:\nhave %q\nwant %q", have, want)
+	}
+
+	// Printer.RenderRange uses the printer's settings.
+	pr := &Printer{HTMLIndent: "  "}
+	if have, want := pr.RenderRange(doc, 0, len(doc.Blocks)), pr.ToHTML(doc); have != want {
+		t.Errorf("Printer.RenderRange(all) != Printer.ToHTML:\nhave %q\nwant %q", have, want)
+	}
+	if have := pr.RenderRange(doc, 0, 2); !strings.Contains(have, "\n    <p>Note A.") {
+		t.Errorf("Printer.RenderRange(0, 2) ignores HTMLIndent:\n%s", have)
+	}
+
+	// Top-level options apply to the blocks in the range.
+	pr = &Printer{
+		Sections:        true,
+		SingleH1:        true,
+		NoParagraphTags: true,
+		BlockHTML: func(b Block, html string) string {
+			return "<!-- block -->\n" + html
+		},
+	}
+	if have, want := pr.RenderRange(doc, 0, 4), pr.ToHTML(doc); have != want {
+		t.Errorf("Printer.RenderRange(all) with top-level options != Printer.ToHTML:\nhave %q\nwant %q", have, want)
+	}
+	have = pr.RenderRange(doc, 0, 2)
+	for _, want := range []string{"<section>\n<!-- block -->\n<h1>One</h1>", "\nSee <a", "</section>\n"} {
+		if !strings.Contains(have, want) {
+			t.Errorf("Printer.RenderRange(0, 2) with top-level options = %q, missing %q", have, want)
+		}
+	}
+}
+
+func TestRoundTrips(t *testing.T) {
+	var p Parser
+	if ok, md := RoundTrips(&p, "# Hello\n\n- *world*\n- again\n"); !ok {
+		t.Errorf("RoundTrips(ok input) = false, %q, want true", md)
+	}
+
+	// From testdata/extra.txt 13.md, listed in roundTripFailures.
+	in := "x\n    <td"
+	ok, md := RoundTrips(&p, in)
+	if ok {
+		t.Fatalf("RoundTrips(%q) = true, want false", in)
+	}
+	if want := Format(p.Parse(in)); md != want {
+		t.Errorf("RoundTrips(%q) = false, %q, want %q", in, md, want)
+	}
+}
+
+func TestLinkDefs(t *testing.T) {
+	in := "[Zeta]: /z\n[alpha]: /a\n[ZETA]: /dup\n[Mid  Label]: /m\n"
+	var p Parser
+	doc := p.Parse(in)
+	var have []string
+	for _, l := range doc.LinkDefs() {
+		have = append(have, l.Label+" "+l.URL)
+	}
+	want := []string{"Zeta /z", "alpha /a", "Mid  Label /m"}
+	if !slices.Equal(have, want) {
+		t.Errorf("LinkDefs() = %q, want %q", have, want)
+	}
+}
+
+func TestBlockHTML(t *testing.T) {
+	in := "# Title\n\n```go\nx := 1\n```\n\n> quote\n>\n> ```\n> nested\n> ```\n"
+	var p Parser
+	doc := p.Parse(in)
+	pr := Printer{
+		BlockHTML: func(b Block, html string) string {
+			if _, ok := b.(*CodeBlock); ok {
+				return `<div class="code">` + "\n" + html + `<button>Copy</button>` + "\n</div>\n"
+			}
+			return html
+		},
+	}
+	have := pr.ToHTML(doc)
+	want := `<h1>Title</h1>
+<div class="code">
+<pre><code class="language-go">x := 1
+</code></pre>
+<button>Copy</button>
+</div>
+<blockquote>
+<p>quote</p>
+<pre><code>nested
+</code></pre>
+</blockquote>
+`
+	if have != want {
+		t.Errorf("ToHTML with BlockHTML:\nhave %q\nwant %q", have, want)
+	}
+}
+
+func TestImageData(t *testing.T) {
+	in := "![dot](dot.gif) ![remote](https://example.com/x.png)\n"
+	var p Parser
+	doc := p.Parse(in)
+	var urls []string
+	pr := Printer{
+		BaseURL: "/img/",
+		ImageData: func(url string) (string, []byte, bool) {
+			urls = append(urls, url)
+			if url == "/img/dot.gif" {
+				return "image/gif", []byte("GIF89a"), true
+			}
+			return "", nil, false
+		},
+	}
+	have := pr.ToHTML(doc)
+	want := `<p><img src="data:image/gif;base64,R0lGODlh" alt="dot" /> <img src="https://example.com/x.png" alt="remote" /></p>` + "\n"
+	if have != want {
+		t.Errorf("ToHTML with ImageData:\nhave %q\nwant %q", have, want)
+	}
+	if want := []string{"/img/dot.gif", "https://example.com/x.png"}; !reflect.DeepEqual(urls, want) {
+		t.Errorf("ImageData urls = %q, want %q", urls, want)
+	}
+}
+
+func TestRenderer(t *testing.T) {
+	p := Parser{Footnote: true}
+	docs := []*Document{
+		p.Parse("Text[^a] and[^b].\n\n[^a]: A.\n[^b]: B.\n"),
+		p.Parse("# Title\n\n> quote\n"),
+		p.Parse("Other[^x].\n\n[^x]: X.\n"),
+	}
+	printers := []*Printer{nil, {ClassPrefix: "md"}}
+	for _, pr := range printers {
+		r := Renderer{Printer: pr}
+		if pr == nil {
+			pr = new(Printer)
+		}
+		for i, doc := range docs {
+			var buf bytes.Buffer
+			if err := r.RenderHTML(doc, &buf); err != nil || buf.String() != pr.ToHTML(doc) {
+				t.Errorf("#%d: RenderHTML = %q, %v, want %q", i, buf.String(), err, pr.ToHTML(doc))
+			}
+			buf.Reset()
+			if err := r.RenderMarkdown(doc, &buf); err != nil || buf.String() != pr.Format(doc) {
+				t.Errorf("#%d: RenderMarkdown = %q, %v, want %q", i, buf.String(), err, pr.Format(doc))
+			}
+		}
+	}
+
+	r := Renderer{Printer: &Printer{MaxOutputBytes: 10}}
+	var buf bytes.Buffer
+	if err := r.RenderHTML(docs[1], &buf); err != ErrOutputTooLarge || buf.Len() != 0 {
+		t.Errorf("RenderHTML with limit = %q, %v, want \"\", ErrOutputTooLarge", buf.String(), err)
+	}
+}
+
+func TestCodeBlockHTML(t *testing.T) {
+	in := "```go title=\"main.go\"\npackage main\n```\n\n> ```\n> x\n> ```\n"
+	var p Parser
+	doc := p.Parse(in)
+	pr := Printer{
+		CodeBlockHTML: func(b *CodeBlock, html string) string {
+			_, title, ok := strings.Cut(b.Info, ` title="`)
+			if !ok {
+				return html
+			}
+			title = strings.TrimSuffix(title, `"`)
+			return `<div class="code-title">` + title + "</div>\n" + html
+		},
+		BlockHTML: func(b Block, html string) string {
+			if _, ok := b.(*CodeBlock); ok {
+				return "<div>\n" + html + "</div>\n"
+			}
+			return html
+		},
+	}
+	have := pr.ToHTML(doc)
+	want := `<div>
+<div class="code-title">main.go</div>
+<pre><code class="language-go">package main
+</code></pre>
+</div>
+<blockquote>
+<pre><code>x
+</code></pre>
+</blockquote>
+`
+	if have != want {
+		t.Errorf("ToHTML with CodeBlockHTML:\nhave %q\nwant %q", have, want)
+	}
+}
+
+func TestTryInvalidTree(t *testing.T) {
+	// A newline in inline code cannot be written as Markdown.
+	b := &Document{Blocks: []Block{&Paragraph{Text: &Text{Inline: Inlines{&Code{Text: "a\nb"}}}}}}
+	if md, err := TryFormat(b); !errors.Is(err, ErrInvalidTree) || md != "" {
+		t.Errorf("TryFormat = %q, %v, want \"\", ErrInvalidTree", md, err)
+	}
+
+	// Format panics with the invalid tree error,
+	// and runtime errors, such as from a nil block,
+	// are passed through rather than reported as errors.
+	panics := func(f func()) (e any) {
+		defer func() { e = recover() }()
+		f()
+		return nil
+	}
+	if e, _ := panics(func() { Format(b) }).(error); !errors.Is(e, ErrInvalidTree) {
+		t.Errorf("Format panic = %v, want ErrInvalidTree", e)
+	}
+	nilBlock := &Document{Blocks: []Block{nil}}
+	if e, ok := panics(func() { TryToHTML(nilBlock) }).(runtime.Error); !ok {
+		t.Errorf("TryToHTML(nil block) panic = %v, want runtime.Error", e)
+	}
+	if e, ok := panics(func() { TryFormat(nilBlock) }).(runtime.Error); !ok {
+		t.Errorf("TryFormat(nil block) panic = %v, want runtime.Error", e)
+	}
+
+	doc := new(Parser).Parse("hello\n")
+	if html, err := TryToHTML(doc); html != "<p>hello</p>\n" || err != nil {
+		t.Errorf("TryToHTML(valid) = %q, %v", html, err)
+	}
+	if md, err := TryFormat(doc); md != "hello\n" || err != nil {
+		t.Errorf("TryFormat(valid) = %q, %v", md, err)
+	}
+}
+
+func TestInlinePositions(t *testing.T) {
+	in := `# Title with ` + "`code`" + `
+
+[ref]: /url
+
+First line.
+A [link
+across](/x) lines and ![image](/y).
+
+> - item <https://example.com>
+>   and [ref]
+`
+	p := Parser{InlinePositions: true}
+	doc := p.Parse(in)
+	var have []string
+	var walk func(Inlines)
+	walk = func(list Inlines) {
+		for _, x := range list {
+			switch x := x.(type) {
+			case *Link:
+				have = append(have, fmt.Sprintf("link %s %d-%d", x.URL, x.StartLine, x.EndLine))
+				walk(x.Inner)
+			case *Image:
+				have = append(have, fmt.Sprintf("image %s %d-%d", x.URL, x.StartLine, x.EndLine))
+			case *AutoLink:
+				have = append(have, fmt.Sprintf("autolink %s %d-%d", x.URL, x.StartLine, x.EndLine))
+			case *Code:
+				have = append(have, fmt.Sprintf("code %s %d-%d", x.Text, x.StartLine, x.EndLine))
+			}
+		}
+	}
+	var visit func(Block)
+	visit = func(b Block) {
+		switch b := b.(type) {
+		case *Heading:
+			walk(b.Text.Inline)
+		case *Paragraph:
+			walk(b.Text.Inline)
+		case *Text:
+			walk(b.Inline)
+		case *Quote:
+			for _, c := range b.Blocks {
+				visit(c)
+			}
+		case *List:
+			for _, c := range b.Items {
+				visit(c)
+			}
+		case *Item:
+			for _, c := range b.Blocks {
+				visit(c)
+			}
+		}
+	}
+	for _, b := range doc.Blocks {
+		visit(b)
+	}
+	want := []string{
+		"code code 1-1",
+		"link /x 6-7",
+		"image /y 7-7",
+		"autolink https://example.com 9-9",
+		"link /url 10-10",
+	}
+	if !slices.Equal(have, want) {
+		t.Errorf("inline positions:\nhave %q\nwant %q", have, want)
+	}
+
+	// Without InlinePositions, positions are left zero.
+	doc = new(Parser).Parse(in)
+	for _, x := range doc.Blocks[1].(*Paragraph).Text.Inline {
+		if l, ok := x.(*Link); ok && l.Position != (Position{}) {
+			t.Errorf("without InlinePositions, link Position = %+v, want zero", l.Position)
+		}
+	}
+}
+
+func TestEncodeCR(t *testing.T) {
+	for _, s := range []string{
+		"a\rb\r",
+		"a\r\nb\nc\r",
+		"a\r\r\n\rb",
+		"a  \rb \r\n",
+	} {
+		if enc := encode(s); decode(enc) != s {
+			t.Errorf("decode(encode(%q)) = %q (encoded %q)", s, decode(enc), enc)
+		}
+	}
+
+	doc, corners := new(Parser).ParseCorners("a\rb\r\n\x00\n")
+	if len(corners) != 1 || corners[0].Line != 3 {
+		t.Errorf("ParseCorners: corners = %v, want NUL byte on line 3", corners)
+	}
+	if have, want := ToHTML(doc), "<p>a\nb\n\uFFFD</p>\n"; have != want {
+		t.Errorf("ToHTML = %q, want %q", have, want)
+	}
+}
+
+func TestHeadingIDFunc(t *testing.T) {
+	p := Parser{
+		HeadingID: true,
+		HeadingIDFunc: func(raw string) (string, bool) {
+			if strings.Contains(raw, " ") {
+				return "", false
+			}
+			return strings.ToLower(raw), true
+		},
+	}
+	in := "# Überblick {#Überblick}\n\n## Two {#a b}\n"
+	doc, corners := p.ParseCorners(in)
+	if len(corners) != 0 {
+		t.Errorf("ParseCorners: unexpected corners %v", corners)
+	}
+	have := ToHTML(doc)
+	want := "<h1 id=\"überblick\">Überblick</h1>\n<h2>Two {#a b}</h2>\n"
+	if have != want {
+		t.Errorf("ToHTML:\nhave %q\nwant %q", have, want)
+	}
+}
+
+func TestAutoLinkScheme(t *testing.T) {
+	var hosts []string
+	p := Parser{
+		AutoLinkText: true,
+		AutoLinkScheme: func(host string) string {
+			hosts = append(hosts, host)
+			if strings.HasSuffix(host, ".internal") {
+				return "http"
+			}
+			return ""
+		},
+	}
+	in := "www.example.com/a and www.corp.internal/b and http://www.example.org\n"
+	have := ToHTML(p.Parse(in))
+	want := `<p><a href="https://www.example.com/a">www.example.com/a</a> and <a href="http://www.corp.internal/b">www.corp.internal/b</a> and <a href="http://www.example.org">http://www.example.org</a></p>` + "\n"
+	if have != want {
+		t.Errorf("ToHTML:\nhave %q\nwant %q", have, want)
+	}
+	if want := []string{"www.example.com", "www.corp.internal"}; !slices.Equal(hosts, want) {
+		t.Errorf("AutoLinkScheme called with %q, want %q", hosts, want)
+	}
+}
+
+func TestToHTMLDocument(t *testing.T) {
+	doc := new(Parser).Parse("Intro.\n\n# The *A* & B\n\n## Next\n")
+	have := ToHTMLDocument(doc, &HTMLDocumentOptions{Title: "file.md", StyleSheet: "/style.css?a&b"})
+	want := `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>The A &amp; B</title>
+<link rel="stylesheet" href="/style.css?a&amp;b">
+</head>
+<body>
+<p>Intro.</p>
+<h1>The <em>A</em> &amp; B</h1>
+<h2>Next</h2>
+</body>
+</html>
+`
+	if have != want {
+		t.Errorf("ToHTMLDocument:\nhave %q\nwant %q", have, want)
+	}
+
+	doc = new(Parser).Parse("No heading.\n")
+	have = ToHTMLDocument(doc, &HTMLDocumentOptions{Title: "a<b>.md"})
+	if !strings.Contains(have, "<title>a&lt;b&gt;.md</title>\n") || strings.Contains(have, "stylesheet") {
+		t.Errorf("ToHTMLDocument without heading:\n%s", have)
+	}
+	if have = ToHTMLDocument(doc, nil); !strings.Contains(have, "<title></title>\n") {
+		t.Errorf("ToHTMLDocument with nil options:\n%s", have)
+	}
+}
+
+func TestTitleSummary(t *testing.T) {
+	var tests = []struct {
+		in      string
+		max     int
+		title   string
+		summary string
+	}{
+		{"# The `go` *command*\n\nRuns  Go\ncommands.\n", 0, "The go command", "Runs Go commands."},
+		{"Intro para.\n\n## Later\n\nMore.\n", 0, "Later", "Intro para."},
+		{"No heading, and a [link](/x) and ![img](/y).\n", 0, "", "No heading, and a link and img."},
+		{"- list only\n", 0, "", ""},
+		{"The quick brown fox jumps.\n", 12, "", "The quick…"},
+		{"Supercalifragilistic.\n", 6, "", "Super…"},
+		{"Short.\n", 6, "", "Short."},
+		{"AB\U0001F468\u200d\U0001F469\u200d\U0001F467\u200d\U0001F466CD\n", 4, "", "AB…"},
+		{"e\u0301e\u0301e\u0301e\u0301\n", 4, "", "e\u0301…"},
+		{"---\ntitle: Front\nsummary: matter\n---\n\n# Real Title\n\nReal summary.\n", 0, "Real Title", "Real summary."},
+		{"---\n- a\n- b\n---\nText.\n", 0, "", "Text."},
+		{"---\n\n# Real Title\n\nIntro paragraph.\n\n---\n\nMore.\n", 0, "Real Title", "Intro paragraph."},
+		{"***\n\nFirst para.\n\nSection\n-------\n\nBody.\n", 0, "Section", "First para."},
+		{"---\n\nNot front matter.\n", 0, "", "Not front matter."},
+	}
+	for _, tt := range tests {
+		doc := new(Parser).Parse(tt.in)
+		if title := doc.Title(); title != tt.title {
+			t.Errorf("Parse(%q).Title() = %q, want %q", tt.in, title, tt.title)
+		}
+		if summary := doc.Summary(tt.max); summary != tt.summary {
+			t.Errorf("Parse(%q).Summary(%d) = %q, want %q", tt.in, tt.max, summary, tt.summary)
+		}
+	}
+}
+
+func TestTrimCodeTrailingSpace(t *testing.T) {
+	// TestFormat cannot test this option,
+	// because trimming changes the code block text.
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{"```go\nx := 1  \n\ty := 2\t\n   \n```\n", "```go\nx := 1\n\ty := 2\n\n```\n"},
+		{"    a  \n          \n      b \n", "    a\n\n      b\n"},
+		{"- ```\n  x \n  ```\n", "  - ```\n    x\n    ```\n"},
+	}
+	pr := Printer{TrimCodeTrailingSpace: true}
+	for _, tt := range tests {
+		doc := new(Parser).Parse(tt.in)
+		if out := pr.Format(doc); out != tt.out {
+			t.Errorf("Format(%q) = %q, want %q", tt.in, out, tt.out)
+		}
+	}
+}
+
+func TestConcat(t *testing.T) {
+	p := Parser{Footnote: true}
+	header := p.Parse("# Title[^1]\n\nSee [x].\n\n[x]: /header\n[y]: /y\n\n[^1]: Header note.\n")
+	body := p.Parse("Body[^1] and [x].\n\n[x]: /body\n[z]: /z\n\n[^1]: Body note.\n")
+	footer := p.Parse("Footer[^1-2].\n\n[^1-2]: Footer note.\n")
+	doc := Concat(header, body, footer)
+
+	if have, want := doc.LinkOrder, []string{"x", "y", "z"}; !slices.Equal(have, want) {
+		t.Errorf("LinkOrder = %q, want %q", have, want)
+	}
+	if url := doc.Links["x"].URL; url != "/header" {
+		t.Errorf("Links[x].URL = %q, want /header", url)
+	}
+
+	have := Format(doc)
+	want := `# Title[^1]
+
+See [x].
+
+Body[^1-2] and [x](/body).
+
+Footer[^1-2-2].
+
+[x]: /header
+[y]: /y
+[z]: /z
+
+
+[^1]: Header note.
+[^1-2]: Body note.
+[^1-2-2]: Footer note.
+`
+	if have != want {
+		t.Errorf("Format(Concat(...)):\nhave %q\nwant %q", have, want)
+	}
+	if html := ToHTML(p.Parse(have)); html != ToHTML(doc) {
+		t.Errorf("Format(Concat(...)) does not round trip:\nhave %s\nwant %s", html, ToHTML(doc))
+	}
+
+	var labels []string
+	for _, note := range doc.Notes {
+		labels = append(labels, note.Label)
+	}
+	if want := []string{"1", "1-2", "1-2-2"}; !slices.Equal(labels, want) {
+		t.Errorf("Notes labels = %q, want %q", labels, want)
+	}
+
+	// Concat copies renamed footnotes instead of modifying its arguments.
+	if have, want := Format(body), "Body[^1] and [x].\n\n[x]: /body\n[z]: /z\n\n\n[^1]: Body note.\n"; have != want {
+		t.Errorf("Format(body) after Concat:\nhave %q\nwant %q", have, want)
+	}
+	if have, want := Format(footer), "Footer[^1-2].\n\n\n[^1-2]: Footer note.\n"; have != want {
+		t.Errorf("Format(footer) after Concat:\nhave %q\nwant %q", have, want)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	p := Parser{Table: true}
+	doc := p.Parse("> - *a [b](/c)*\n\n| h |\n|---|\n| `d` |\n")
+	var have []string
+	Walk(doc, func(b Block) bool {
+		have = append(have, fmt.Sprintf("%T", b))
+		return true
+	}, func(x Inline) bool {
+		have = append(have, fmt.Sprintf("%T", x))
+		_, ok := x.(*Link)
+		return !ok
+	})
+	want := []string{
+		"*markdown.Document", "*markdown.Quote", "*markdown.List", "*markdown.Item",
+		"*markdown.Text", "*markdown.Emph", "*markdown.Plain", "*markdown.Link",
+		"*markdown.Table", "*markdown.Text", "*markdown.Plain", "*markdown.Text", "*markdown.Code",
+	}
+	if !slices.Equal(have, want) {
+		t.Errorf("Walk:\nhave %q\nwant %q", have, want)
+	}
+}
+
+func TestFootnotes(t *testing.T) {
+	p := Parser{Footnote: true}
+	doc := p.Parse("Text[^b] and[^a].\n\n[^a]: A.\n[^unused]: Unused.\n[^b]: B.\n")
+	var labels []string
+	for _, note := range doc.Footnotes() {
+		labels = append(labels, note.Label)
+	}
+	if want := []string{"a", "unused", "b"}; !slices.Equal(labels, want) {
+		t.Errorf("Footnotes() labels = %q, want %q", labels, want)
+	}
+
+	// Footnotes missing from Notes are found by reference.
+	doc.Notes = doc.Notes[:1]
+	labels = nil
+	for _, note := range doc.Footnotes() {
+		labels = append(labels, note.Label)
+	}
+	if want := []string{"a", "b"}; !slices.Equal(labels, want) {
+		t.Errorf("Footnotes() with short Notes labels = %q, want %q", labels, want)
+	}
+}
+
+func TestPresets(t *testing.T) {
+	in := "| a |\n|---|\n| ~b~ |\n\n- [x] www.example.com\n"
+	have := ToHTML(NewGFMParser().Parse(in))
+	for _, want := range []string{"<table>", "<del>b</del>", `<input checked="" disabled="" type="checkbox">`, `<a href="https://www.example.com">`} {
+		if !strings.Contains(have, want) {
+			t.Errorf("NewGFMParser: HTML missing %q:\n%s", want, have)
+		}
+	}
+
+	have = ToHTML(NewCommonMarkParser().Parse(in))
+	for _, bad := range []string{"<table>", "<del>", "<input", "<a "} {
+		if strings.Contains(have, bad) {
+			t.Errorf("NewCommonMarkParser: HTML contains %q:\n%s", bad, have)
+		}
+	}
+
+	// StrictCommonMark keeps the options that are not syntax extensions.
+	p := Parser{StrictCommonMark: true, ControlCharPolicy: ControlCharStrip, InlinePositions: true, ExpandTabs: true}
+	doc := p.Parse("a\x01b\tc [d](/e)\n")
+	if have, want := ToHTML(doc), "<p>ab  c <a href=\"/e\">d</a></p>\n"; have != want {
+		t.Errorf("StrictCommonMark with ControlCharStrip, ExpandTabs:\nhave %q\nwant %q", have, want)
+	}
+	if link := doc.Blocks[0].(*Paragraph).Text.Inline[1].(*Link); link.StartLine != 1 {
+		t.Errorf("StrictCommonMark with InlinePositions: link StartLine = %d, want 1", link.StartLine)
+	}
+}
+
+func TestRenderFootnotesHTML(t *testing.T) {
+	p := Parser{Footnote: true}
+	doc := p.Parse("A[^b] and B[^a] and A again[^b].\n\n[^a]: Note a.\n[^b]: Note b.\n")
+	body, notes := doc.RenderBodyHTML(), doc.RenderFootnotesHTML()
+	if body+notes != ToHTML(doc) {
+		t.Errorf("RenderBodyHTML+RenderFootnotesHTML != ToHTML:\nbody %q\nnotes %q\nToHTML %q", body, notes, ToHTML(doc))
+	}
+	if strings.Contains(body, "footnotes") || !strings.HasPrefix(notes, `<div class="footnotes">`) {
+		t.Errorf("bad split:\nbody %q\nnotes %q", body, notes)
+	}
+	if !strings.Contains(body, `href="#fn-1"`) || !strings.Contains(notes, `<li id="fn-1">`+"\n<p>Note b.") {
+		t.Errorf("numbering mismatch:\nbody %q\nnotes %q", body, notes)
+	}
+
+	// The footnotes section matches ToHTML for other Printer settings
+	// and for references in nested blocks, tables, links, and images.
+	p.Table = true
+	doc = p.Parse("> - *x[^c]* [y[^b]](/y) ![z[^d]](/z)\n\n| h[^a] |\n|---|\n| c[^c] |\n\n[^a]: A.\n[^b]: B.\n[^c]: C.\n[^d]: D.\n")
+	pr := &Printer{HTMLIndent: "  ", FootnotePopovers: true}
+	body, notes = pr.RenderBodyHTML(doc), pr.RenderFootnotesHTML(doc)
+	if body+notes != pr.ToHTML(doc) {
+		t.Errorf("Printer.RenderBodyHTML+RenderFootnotesHTML != ToHTML:\nbody %q\nnotes %q\nToHTML %q", body, notes, pr.ToHTML(doc))
+	}
+
+	doc = p.Parse("No notes.\n")
+	if notes := doc.RenderFootnotesHTML(); notes != "" {
+		t.Errorf("RenderFootnotesHTML without footnotes = %q, want \"\"", notes)
+	}
+}
+
+func TestSentencePerLine(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{"One. Two! Three? four. 1.5 times.\n", "One.\nTwo!\nThree? four. 1.5 times.\n"},
+		{"He said \"Stop.\" Then (he left.) And  more.\n", "He said \"Stop.\"\nThen (he left.)\nAnd  more.\n"},
+		{"Keep `a. B` and [c. D](/x) and ![e. F](/y). *G. H.*\n", "Keep `a. B` and [c. D](/x) and ![e. F](/y). *G.\nH.*\n"},
+		{"# Title. Two\n\n| a. B |\n|---|\n", "# Title. Two\n| a. B |\n| ---- |\n"},
+		{"- Tight. Item.\n- Two.\n\n> Quote. Here.\n", "  - Tight.\n    Item.\n  - Two.\n> Quote.\n> Here.\n"},
+		{"Line one.\nLine two. Three.\n", "Line one.\nLine two.\nThree.\n"},
+	}
+	p := Parser{Table: true}
+	pr := Printer{SentencePerLine: true}
+	for _, tt := range tests {
+		doc := p.Parse(tt.in)
+		out := pr.Format(doc)
+		if out != tt.out {
+			t.Errorf("Format(%q) = %q, want %q", tt.in, out, tt.out)
+		}
+	}
+}
+
+func TestEmojiSymbolNames(t *testing.T) {
+	// Names like :+1:, :-1:, and :e-mail: contain characters
+	// that other inline parsers also care about.
+	parsers := []Parser{
+		{Emoji: true},
+		{Emoji: true, SmartDash: true, SmartDot: true, SmartQuote: true, Strikethrough: true, AutoLinkText: true},
+	}
+	n := 0
+	for name, text := range emoji {
+		if strings.Trim(name, "abcdefghijklmnopqrstuvwxyz_") == "" {
+			continue
+		}
+		n++
+		for _, p := range parsers {
+			for _, in := range []string{":" + name + ":", "a :" + name + ": b", "x:" + name + "::" + name + ":"} {
+				doc := p.Parse(in)
+				var got []*Emoji
+				for _, x := range doc.Blocks[0].(*Paragraph).Text.Inline {
+					if e, ok := x.(*Emoji); ok {
+						got = append(got, e)
+					}
+				}
+				want := 1 + strings.Count(in, "::")
+				if len(got) != want {
+					t.Errorf("%+v: Parse(%q) found %d emoji, want %d", p, in, len(got), want)
+					continue
+				}
+				for _, e := range got {
+					if e.Name != ":"+name+":" || e.Text != text {
+						t.Errorf("%+v: Parse(%q) = Emoji{%q, %q}, want Emoji{%q, %q}", p, in, e.Name, e.Text, ":"+name+":", text)
+					}
+				}
+			}
+		}
+	}
+	if n == 0 {
+		t.Fatalf("no emoji names with symbols")
+	}
+}
+
+func TestNormalizeLinkLabel(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{"Foo", "foo"},
+		{"  Foo \t\n  Bar ", "foo bar"},
+		{"ẞ", "ss"},
+		{`a\]b`, `a\]b`},
+		{`a\\]b`, ""},
+		{"a[b", ""},
+	}
+	for _, tt := range tests {
+		if out := NormalizeLinkLabel(tt.in); out != tt.out {
+			t.Errorf("NormalizeLinkLabel(%q) = %q, want %q", tt.in, out, tt.out)
+		}
+	}
+
+	// Keys must match those in Document.Links.
+	doc := new(Parser).Parse("[Foo  Bar]: /x\n[a\\]b]: /y\n[c\\]d]: /z\n\n[a\\]b] [c\\]d]\n")
+	for _, key := range doc.LinkOrder {
+		if k := NormalizeLinkLabel(doc.Links[key].Label); k != key {
+			t.Errorf("NormalizeLinkLabel(%q) = %q, want %q", doc.Links[key].Label, k, key)
+		}
+	}
+	if len(doc.Links) != 3 {
+		t.Errorf("len(doc.Links) = %d, want 3", len(doc.Links))
+	}
+	want := "<p><a href=\"/y\">a]b</a> <a href=\"/z\">c]d</a></p>\n"
+	if out := ToHTML(doc); out != want {
+		t.Errorf("ToHTML = %q, want %q", out, want)
+	}
+}
+
+func TestMergeThematicBreaks(t *testing.T) {
+	in := "***\n---\n___\n\ntext\n\n***\n\n<!-- x -->\n\n***\n\n> ***\n> ***\n"
+	doc := new(Parser).Parse(in)
+	pr := Printer{MergeThematicBreaks: true}
+	have := pr.Format(doc)
+	want := "***\n\ntext\n\n***\n\n<!-- x -->\n\n***\n> ***\n"
+	if have != want {
+		t.Errorf("Format:\nhave %q\nwant %q", have, want)
+	}
+	if have := Format(doc); strings.Count(have, "***") != 7 {
+		t.Errorf("Format without MergeThematicBreaks merged breaks:\n%s", have)
+	}
+}
+
+func TestTightListBlanks(t *testing.T) {
+	tests := []struct {
+		in       string
+		loose    bool // loose by CommonMark rules
+		cosmetic bool // loose only because of cosmetic blank lines
+	}{
+		{"- a\n\n  - b\n- c\n", true, true},
+		{"- a\n\n  > b\n- c\n", true, true},
+		{"- a\n\n  ```\n  b\n  ```\n", true, true},
+		{"- a\n\n  b\n- c\n", true, false},
+		{"- a\n  - b\n\n- c\n", true, false},
+		{"- a\n  - b\n- c\n", false, false},
+	}
+	for _, tt := range tests {
+		for _, p := range []*Parser{{}, {TightListBlanks: true}} {
+			list := p.Parse(tt.in).Blocks[0].(*List)
+			want := tt.loose && !(p.TightListBlanks && tt.cosmetic)
+			if list.Loose != want {
+				t.Errorf("Parser{TightListBlanks: %v}.Parse(%q): Loose=%v, want %v", p.TightListBlanks, tt.in, list.Loose, want)
+			}
+		}
+	}
+}
+
+func TestSingleH1(t *testing.T) {
+	doc := new(Parser).Parse("## Intro\n\n# Title\n\n> # Quoted\n\n### Sub\n\nOther\n=====\n")
+	pr := Printer{SingleH1: true}
+	want := "## Intro\n\n# Title\n> ## Quoted\n\n### Sub\n\n## Other\n"
+	if have := pr.Format(doc); have != want {
+		t.Errorf("Format:\nhave %q\nwant %q", have, want)
+	}
+	if have := pr.ToHTML(doc); strings.Count(have, "<h1>") != 1 || strings.Count(have, "<h2>") != 3 {
+		t.Errorf("ToHTML = %q, want one h1 and three h2", have)
+	}
+	if have := Format(doc); strings.Count(have, "\n# ") != 2 {
+		t.Errorf("Format without SingleH1 demoted headings:\n%s", have)
+	}
+}
+
+func TestPlainBackslashRoundTrip(t *testing.T) {
+	texts := []Inlines{
+		{&Plain{Text: `C:\`}, &Emph{Marker: "*", Inner: Inlines{&Plain{Text: "x"}}}},
+		{&Plain{Text: `a\`}, &SoftBreak{}, &Plain{Text: `b\`}},
+		{&Link{URL: "/u", Inner: Inlines{&Plain{Text: `C:\*.txt\`}}}},
+		{&Plain{Text: `C:\Users\*\\x\`}},
+	}
+	for _, inl := range texts {
+		doc := &Document{Blocks: []Block{&Paragraph{Text: &Text{Inline: inl}}}}
+		md := Format(doc)
+		if have, want := ToHTML(new(Parser).Parse(md)), ToHTML(doc); have != want {
+			t.Errorf("Format = %q, which renders as %q, want %q", md, have, want)
+		}
+	}
+}
+
+func TestCodeBackticksRoundTrip(t *testing.T) {
+	texts := []string{
+		"`",
+		"``",
+		"```",
+		"` `",
+		"a`b",
+		"a``b```c",
+		"```go",
+		" `` ",
+		"x ",
+		strings.Repeat("`", maxBackticks-1),
+	}
+	for _, text := range texts {
+		doc := &Document{Blocks: []Block{&Paragraph{Text: &Text{Inline: Inlines{&Plain{"a "}, &Code{Text: text}, &Plain{" b"}}}}}}
+		md := Format(doc)
+		doc1 := new(Parser).Parse(md)
+		inl := doc1.Blocks[0].(*Paragraph).Text.Inline
+		if len(inl) != 3 {
+			t.Errorf("Code{%q}: Format = %q, reparsed as %d inlines, want 3", text, md, len(inl))
+			continue
+		}
+		if c, ok := inl[1].(*Code); !ok || c.Text != text {
+			t.Errorf("Code{%q}: Format = %q, reparsed as %#v", text, md, inl[1])
+		}
+	}
+}
+
+func TestDeferInline(t *testing.T) {
+	p := Parser{DeferInline: true}
+	doc := p.Parse("# Title *one*\n\nSee [x].\n\n[x]: /url\n")
+	h := doc.Blocks[0].(*Heading)
+	if h.Text.Inline != nil || h.Text.Raw != "Title *one*" {
+		t.Fatalf("deferred heading Text = %v, %q, want nil, %q", h.Text.Inline, h.Text.Raw, "Title *one*")
+	}
+	para := doc.Blocks[1].(*Paragraph)
+	para.Text.ParseInline(&p, doc)
+	if para.Text.Raw != "" {
+		t.Errorf("after ParseInline, Raw = %q, want \"\"", para.Text.Raw)
+	}
+	if have, want := ToHTML(para), `<p>See <a href="/url">x</a>.</p>`+"\n"; have != want {
+		t.Errorf("after ParseInline, ToHTML = %q, want %q", have, want)
+	}
+}
+
+func TestUsedLinks(t *testing.T) {
+	const input = "# [A]\n\nSee [b][] and [c].\n\n[a]: /a\n[b]: /b\n[d]: /d\n"
+	var p Parser
+	doc := p.Parse(input)
+	if want := map[string]bool{"a": true, "b": true}; !reflect.DeepEqual(doc.UsedLinks, want) {
+		t.Errorf("UsedLinks = %v, want %v", doc.UsedLinks, want)
+	}
+
+	// Deferred text adds its references when parsed.
+	p.DeferInline = true
+	doc = p.Parse(input)
+	if len(doc.UsedLinks) != 0 {
+		t.Errorf("deferred UsedLinks = %v, want empty", doc.UsedLinks)
+	}
+	doc.Blocks[1].(*Paragraph).Text.ParseInline(&p, doc)
+	if want := map[string]bool{"b": true}; !reflect.DeepEqual(doc.UsedLinks, want) {
+		t.Errorf("after ParseInline, UsedLinks = %v, want %v", doc.UsedLinks, want)
+	}
+
+	// Concat merges the used links.
+	p.DeferInline = false
+	doc = Concat(p.Parse(input), p.Parse("[d]\n\n[d]: /d\n"))
+	if want := map[string]bool{"a": true, "b": true, "d": true}; !reflect.DeepEqual(doc.UsedLinks, want) {
+		t.Errorf("Concat UsedLinks = %v, want %v", doc.UsedLinks, want)
+	}
+	doc.Blocks = doc.Blocks[:2]
+	doc.UsedLinks = map[string]bool{"b": true}
+	pr := Printer{DropUnusedLinks: true}
+	if have, want := pr.Format(doc), "# [A](/a)\n\nSee [b][] and [c].\n\n[b]: /b\n"; have != want {
+		t.Errorf("Format with DropUnusedLinks = %q, want %q", have, want)
+	}
+}
+
+func TestOnLink(t *testing.T) {
+	var seen []string
+	p := Parser{
+		AutoLinkText: true,
+		OnLink: func(l *Link) {
+			seen = append(seen, "link "+l.URL)
+			if strings.HasPrefix(l.URL, "/old/") {
+				l.URL = "/new/" + strings.TrimPrefix(l.URL, "/old/")
+			}
+		},
+		OnImage: func(i *Image) {
+			seen = append(seen, "image "+i.URL)
+		},
+	}
+	const input = "[*a*](/old/a) [![b](b.png)][c] <http://d> http://e\n\n[c]: /c\n"
+	doc := p.Parse(input)
+	want := []string{"link /old/a", "image b.png", "link /c", "link http://e"}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("callbacks saw %q, want %q", seen, want)
+	}
+	html := `<p><a href="/new/a"><em>a</em></a> <a href="/c"><img src="b.png" alt="b" /></a> <a href="http://d">http://d</a> <a href="http://e">http://e</a></p>` + "\n"
+	if have := ToHTML(doc); have != html {
+		t.Errorf("ToHTML = %q, want %q", have, html)
+	}
+
+	// The callbacks are kept in strict mode and for deferred text.
+	seen = nil
+	p.StrictCommonMark = true
+	p.DeferInline = true
+	doc = p.Parse(input)
+	if seen != nil {
+		t.Errorf("deferred callbacks saw %q, want none", seen)
+	}
+	doc.Blocks[0].(*Paragraph).Text.ParseInline(&p, doc)
+	want = want[:3]
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("strict callbacks saw %q, want %q", seen, want)
+	}
+}
+
+func TestFootnotePopoverSelf(t *testing.T) {
+	// A footnote referring to itself must not print popovers recursively.
+	p := Parser{Footnote: true}
+	doc := p.Parse("Self[^s].\n\n[^s]: Again[^s].\n")
+	pr := Printer{FootnotePopovers: true}
+	have := pr.ToHTML(doc)
+	want := `<p>Self<sup class="fn"><a id="fnref-1" href="#fn-1">1</a></sup>` +
+		`<span class="fn-content" hidden>Again<sup class="fn"><a href="#fn-1">1</a></sup>.</span>.</p>` + "\n" +
+		`<div class="footnotes">Footnotes</div>` + "\n" +
+		"<ol>\n" +
+		`<li id="fn-1">` + "\n" +
+		`<p>Again<sup class="fn"><a id="fnref-1-2" href="#fn-1">1</a></sup>` +
+		`<span class="fn-content" hidden>Again<sup class="fn"><a href="#fn-1">1</a></sup>.</span>.` + "\n" +
+		`<a class="fnref" href="#fnref-1">↩</a>` + "\n" +
+		`<a class="fnref" href="#fnref-1-2">↩</a></p>` + "\n" +
+		"</li>\n" +
+		"</ol>\n"
+	if have != want {
+		t.Errorf("ToHTML:\nhave %q\nwant %q", have, want)
+	}
+}
+
+func TestRefForm(t *testing.T) {
+	doc := new(Parser).Parse("[a][Ref] ![b][] [c] [d](/d)\n\n[ref]: /r\n[b]: /b\n[c]: /c\n")
+	text := doc.Blocks[0].(*Paragraph).Text
+	var have []string
+	for _, x := range text.Inline {
+		switch x := x.(type) {
+		case *Link:
+			have = append(have, fmt.Sprintf("link %q %d", x.Label, x.Ref))
+		case *Image:
+			have = append(have, fmt.Sprintf("image %q %d", x.Label, x.Ref))
+		}
+	}
+	want := []string{
+		fmt.Sprintf("link %q %d", "Ref", RefFull),
+		fmt.Sprintf("image %q %d", "b", RefCollapsed),
+		fmt.Sprintf("link %q %d", "c", RefShortcut),
+		fmt.Sprintf("link %q %d", "", RefNone),
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("links = %q, want %q", have, want)
+	}
+
+	// Without the definitions, references print inline.
+	if have, want := FormatBlock(text), "[a](/r) ![b](/b) [c](/c) [d](/d)"; have != want {
+		t.Errorf("FormatBlock = %q, want %q", have, want)
+	}
+
+	// A link edited to differ from its definition prints inline.
+	text.Inline[0].(*Link).URL = "/new"
+	if have, want := Format(doc), "[a](/new) ![b][] [c] [d](/d)\n\n[b]: /b\n[c]: /c\n[ref]: /r\n"; have != want {
+		t.Errorf("Format after edit = %q, want %q", have, want)
+	}
+}
+
+func TestFootnotePopoverNumbering(t *testing.T) {
+	// Popovers must not change footnote numbering, even when
+	// a footnote refers to a footnote that is not yet numbered.
+	p := Parser{Footnote: true}
+	doc := p.Parse("A[^a] B[^c]\n\n[^a]: note a cites[^b]\n[^b]: note b\n[^c]: note c\n")
+	plain := ToHTML(doc)
+	pr := Printer{FootnotePopovers: true}
+	popover := pr.ToHTML(doc)
+	stripped := regexp.MustCompile(`<span class="fn-content" hidden>.*?</span>`).ReplaceAllString(popover, "")
+	if stripped != plain {
+		t.Errorf("ToHTML with popovers, without spans:\n%s\nwant:\n%s", stripped, plain)
+	}
+	if want := `<span class="fn-content" hidden>note a cites[^b]</span>`; !strings.Contains(popover, want) {
+		t.Errorf("ToHTML with popovers = %q, missing %q", popover, want)
+	}
+}
+
+func TestPlainDel(t *testing.T) {
+	p := Parser{Strikethrough: true}
+	doc := p.Parse("Was ~~old price~~ now *new* and ~gone~ ![a ~~b~~](c).\n")
+	text := doc.Blocks[0].(*Paragraph).Text
+	tests := []struct {
+		del  PlainDel
+		want string
+	}{
+		{PlainDelText, "Was old price now new and gone a b."},
+		{PlainDelMarkers, "Was ~~old price~~ now new and ~gone~ a ~~b~~."},
+		{PlainDelCombining, "Was o\u0336l\u0336d\u0336 p\u0336r\u0336i\u0336c\u0336e\u0336 now new and g\u0336o\u0336n\u0336e\u0336 a b\u0336."},
+	}
+	for _, tt := range tests {
+		pr := Printer{PlainDel: tt.del}
+		if have := pr.PlainText(text); have != tt.want {
+			t.Errorf("PlainText with PlainDel %d = %q, want %q", tt.del, have, tt.want)
+		}
+		if have, want := pr.ToHTML(doc), ToHTML(doc); have != want {
+			t.Errorf("ToHTML with PlainDel %d = %q, want %q", tt.del, have, want)
+		}
+	}
+}
+
+func TestTextHTML(t *testing.T) {
+	p := Parser{Footnote: true}
+	doc := p.Parse("## The `go` *command*[^1] & <b>more</b>\n\n[^1]: Note.\n")
+	text := doc.Blocks[0].(*Heading).Text
+	want := `The <code>go</code> <em>command</em><sup class="fn"><a id="fnref-1" href="#fn-1">1</a></sup> &amp; <b>more</b>`
+	if have := text.HTML(); have != want {
+		t.Errorf("HTML() = %q, want %q", have, want)
+	}
+	pr := Printer{ARIA: true}
+	if have := pr.TextHTML(text); !strings.Contains(have, `role="doc-noteref"`) {
+		t.Errorf("Printer{ARIA: true}.TextHTML = %q, want doc-noteref role", have)
+	}
+}
+
+func TestNonBreakingSpace(t *testing.T) {
+	in := "Mr.\\ Smith\n"
+	p := Parser{NonBreakingSpace: true}
+	doc := p.Parse(in)
+	text := doc.Blocks[0].(*Paragraph).Text
+	if have, want := new(Printer).PlainText(text), "Mr.\u00a0Smith"; have != want {
+		t.Errorf("PlainText = %q, want %q", have, want)
+	}
+	if have := Format(doc); have != in {
+		t.Errorf("Format = %q, want %q", have, in)
+	}
+	if have, want := ToHTML(new(Parser).Parse(in)), "<p>Mr.\\ Smith</p>\n"; have != want {
+		t.Errorf("ToHTML without NonBreakingSpace = %q, want %q", have, want)
+	}
+}
+
+func TestEscapeSet(t *testing.T) {
+	doc := &Document{Blocks: []Block{
+		&Paragraph{Text: &Text{Inline: Inlines{
+			&Plain{Text: "1 * 2 = #2 "},
+			&Link{URL: "/a(b)<c>", Title: "x*y (z)", TitleChar: '"', Inner: Inlines{&Plain{Text: "link"}}},
+		}}},
+	}}
+	tests := []struct {
+		escapes *EscapeSet
+		want    string
+	}{
+		{nil, `1 * 2 = #2 [link](/a\(b\)\<c\> "x\*y \(z\)")` + "\n"},
+		{&EscapeSet{Text: "*#", URL: DefaultURLEscapes, Title: DefaultTitleEscapes}, `1 \* 2 = \#2 [link](/a\(b\)\<c\> "x\*y \(z\)")` + "\n"},
+		{&EscapeSet{URL: "()", Title: "()"}, `1 * 2 = #2 [link](/a\(b\)<c> "x*y \(z\)")` + "\n"},
+	}
+	for _, tt := range tests {
+		pr := Printer{Escapes: tt.escapes}
+		if have := pr.Format(doc); have != tt.want {
+			t.Errorf("Format with Escapes %+v:\nhave %q\nwant %q", tt.escapes, have, tt.want)
+		}
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain text, AT&T (1.5)", "plain text, AT&T (1.5)"},
+		{"*Hi* [x]", `\*Hi\* \[x\]`},
+		{"a_b `c` <d> &amp; ~e~ | f\\", "a\\_b \\`c\\` \\<d> \\&amp; \\~e\\~ \\| f\\\\"},
+		{"  # title\r\n1. one\n- two  \n> three", "\\# title\n1\\. one\n\\- two\n\\> three"},
+	}
+	for _, tt := range tests {
+		if have := EscapeText(tt.in); have != tt.want {
+			t.Errorf("EscapeText(%q) = %q, want %q", tt.in, have, tt.want)
+		}
+	}
+}
+
+func TestEscapeURL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"https://example.com/?a=1&b=2", "https://example.com/?a=1&b=2"},
+		{"/a(b)", `/a\(b\)`},
+		{"", "<>"},
+		{"a b<c>", `<a b\<c\>>`},
+		{`C:\dir\`, `C:\dir\\`},
+		{`a\*b&amp;c`, `a\\*b\&amp;c`},
+		{"a\nb", "a%0Ab"},
+	}
+	for _, tt := range tests {
+		if have := EscapeURL(tt.in); have != tt.want {
+			t.Errorf("EscapeURL(%q) = %q, want %q", tt.in, have, tt.want)
+		}
+	}
+}
+
+func TestHasMarkdown(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"", false},
+		{"Just some text, with punctuation: (a) \"b\" 'c' 1.5 - d!\n", false},
+		{"Two\nlines.\n\nTwo paragraphs.", false},
+		{"   three spaces", false},
+		{"    four spaces", true},
+		{"\ttab", true},
+		{"some *emphasis*", true},
+		{"a_b", true},
+		{"a `code` span", true},
+		{"a [link]", true},
+		{"a <b>tag</b>", true},
+		{"an &amp; entity", true},
+		{"an \\* escape", true},
+		{"# heading", true},
+		{"text\n---", true},
+		{"text\n===", true},
+		{"- item", true},
+		{"+ item", true},
+		{"12. item", true},
+		{"12) item", true},
+		{"> quote", true},
+		{"~~~\ncode\n~~~", true},
+		{"hard  \nbreak", true},
+		{"hard  \r\nbreak", true},
+	}
+	for _, tt := range tests {
+		if have := HasMarkdown(tt.in); have != tt.want {
+			t.Errorf("HasMarkdown(%q) = %v, want %v", tt.in, have, tt.want)
+		}
+	}
+}
+
+func TestFinalNewline(t *testing.T) {
+	tests := []struct {
+		in     string
+		policy FinalNewline
+		out    string
+	}{
+		{"text\n\n\n", FinalNewlineSingle, "text\n"},
+		{"text", FinalNewlineSingle, "text\n"},
+		{"text\n", FinalNewlineNone, "text"},
+		{"[x]\n\n[x]: /u\n", FinalNewlineNone, "[x]\n\n[x]: /u"},
+		{"text\n", FinalNewlinePreserve, "text\n"},
+		{"text\r\n", FinalNewlinePreserve, "text\n"},
+		{"text", FinalNewlinePreserve, "text"},
+		{"text[^1]\n\n[^1]: note", FinalNewlinePreserve, "text[^1]\n\n\n[^1]: note"},
+		{"text[^1]\n\n[^1]: note", FinalNewlineSingle, "text[^1]\n\n\n[^1]: note\n"},
+		{"", FinalNewlineSingle, ""},
+		{"\n\n", FinalNewlinePreserve, ""},
+	}
+	p := Parser{Footnote: true}
+	for _, tt := range tests {
+		pr := Printer{FinalNewline: tt.policy}
+		if out := pr.Format(p.Parse(tt.in)); out != tt.out {
+			t.Errorf("Format(%q) with FinalNewline %d = %q, want %q", tt.in, tt.policy, out, tt.out)
+		}
+	}
+}
+
+func TestFormatBlock(t *testing.T) {
+	p := Parser{Footnote: true}
+	doc := p.Parse("Para[^1] with [link][x].\n\n- a\n- b\n\n> quote\n\n[x]: /x\n[^1]: Note.\n")
+	var tests = []struct {
+		b   Block
+		out string
+	}{
+		{doc.Blocks[0], "Para[^1] with [link](/x)."},
+		{doc.Blocks[1], "  - a\n  - b"},
+		{doc.Blocks[2], "> quote"},
+		{doc, "Para[^1] with [link](/x).\n\n  - a\n  - b\n> quote"},
+	}
+	for _, tt := range tests {
+		if out := FormatBlock(tt.b); out != tt.out {
+			t.Errorf("FormatBlock(%T) = %q, want %q", tt.b, out, tt.out)
+		}
+	}
+	if out := Format(doc.Blocks[0]); !strings.Contains(out, "[^1]: Note.") {
+		t.Errorf("Format(%T) = %q, want footnote", doc.Blocks[0], out)
+	}
+}
+
+func TestCodeBlocks(t *testing.T) {
+	p := Parser{Footnote: true, Details: true}
+	in := "```go\none\n```\n\n> - item\n>\n>       two\n\n:::details\n~~~\nthree\n~~~\n:::\n\nText[^1].\n\n[^1]: Note.\n\n    ```\n    four\n    ```\n\n        five\n"
+	doc := p.Parse(in)
+	var texts, infos []string
+	for _, b := range CodeBlocks(doc) {
+		texts = append(texts, strings.Join(b.Text, "\n"))
+		infos = append(infos, b.Info)
+	}
+	if want := []string{"one", "two", "three", "four", "five"}; !slices.Equal(texts, want) {
+		t.Errorf("CodeBlocks texts = %q, want %q", texts, want)
+	}
+	if want := []string{"go", "", "", "", ""}; !slices.Equal(infos, want) {
+		t.Errorf("CodeBlocks infos = %q, want %q", infos, want)
+	}
+}
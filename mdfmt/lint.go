@@ -0,0 +1,144 @@
+// Copyright 2024 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"rsc.io/markdown"
+)
+
+// A problem is a style issue reported by -lint.
+type problem struct {
+	line int
+	msg  string
+}
+
+// lint returns the style problems in the Markdown document text,
+// sorted by line number.
+func lint(text string) []problem {
+	// UnresolvedLinks makes the parser report references
+	// to undefined links as corner cases.
+	p := markdown.Parser{Table: true, UnresolvedLinks: markdown.UnresolvedLinksText}
+	doc, corners := p.ParseCorners(text)
+
+	l := &linter{lines: strings.Split(text, "\n")}
+	for _, c := range corners {
+		if c.Reason == "unresolved link reference" {
+			l.report(c.Line, "undefined link reference")
+			continue
+		}
+		l.report(c.Line, "may render differently in other Markdown implementations: %s", c.Reason)
+	}
+	l.blocks(doc.Blocks)
+	for _, note := range doc.Notes {
+		l.blocks(note.Blocks)
+	}
+
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		return l.problems[i].line < l.problems[j].line
+	})
+	return l.problems
+}
+
+type linter struct {
+	lines    []string // input lines
+	level    int      // level of last heading
+	h1       int      // line of first level-1 heading, or 0
+	problems []problem
+}
+
+func (l *linter) report(line int, format string, args ...any) {
+	l.problems = append(l.problems, problem{line, fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) blocks(list []markdown.Block) {
+	for _, b := range list {
+		markdown.Walk(b, l.block, nil)
+	}
+}
+
+// block checks b, called by [markdown.Walk] for each block.
+func (l *linter) block(b markdown.Block) bool {
+	switch b := b.(type) {
+	case *markdown.Heading:
+		l.heading(b)
+	case *markdown.Table:
+		l.table(b)
+	}
+	return true
+}
+
+// heading checks for headings that skip levels,
+// level-1 headings after the first,
+// and headings that end in punctuation.
+func (l *linter) heading(h *markdown.Heading) {
+	if l.level > 0 && h.Level > l.level+1 {
+		l.report(h.StartLine, "heading level %d skips level %d", h.Level, l.level+1)
+	}
+	l.level = h.Level
+	if h.Level == 1 {
+		if l.h1 > 0 {
+			l.report(h.StartLine, "multiple level-1 headings (first on line %d)", l.h1)
+		} else {
+			l.h1 = h.StartLine
+		}
+	}
+
+	if h.Text == nil || len(h.Text.Inline) == 0 {
+		return
+	}
+	if x, ok := h.Text.Inline[len(h.Text.Inline)-1].(*markdown.Plain); ok {
+		r, _ := utf8.DecodeLastRuneInString(x.Text)
+		if strings.ContainsRune(".,;:!", r) {
+			l.report(h.StartLine, "heading ends in punctuation %q", r)
+		}
+	}
+}
+
+// table checks for rows with a different number of cells than the header.
+// The parser pads and truncates rows to the header width,
+// so the check counts the cells in the input lines.
+func (l *linter) table(t *markdown.Table) {
+	if t.StartLine < 1 || t.EndLine > len(l.lines) {
+		return
+	}
+	for line := t.StartLine + 2; line <= t.EndLine; line++ {
+		if n := tableCells(l.lines[line-1]); n != len(t.Header) {
+			l.report(line, "table row has %s, header has %d", cells(n), len(t.Header))
+		}
+	}
+}
+
+// cells returns a count of n cells, like "1 cell" or "2 cells".
+func cells(n int) string {
+	if n == 1 {
+		return "1 cell"
+	}
+	return fmt.Sprintf("%d cells", n)
+}
+
+// tableCells returns the number of cells in the table row s.
+func tableCells(s string) int {
+	s = strings.TrimRight(s, " \t\r")
+	s = strings.TrimLeft(s, " \t>")
+	s = strings.TrimPrefix(s, "|")
+	if strings.HasSuffix(s, "|") && !strings.HasSuffix(s, `\|`) {
+		s = s[:len(s)-1]
+	}
+	n := 1
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '|':
+			n++
+		}
+	}
+	return n
+}
@@ -6,12 +6,26 @@
 //
 // Usage:
 //
-//	mdfmt [-w] [file...]
+//	mdfmt [-w] [-lint] [-droplinks] [file...]
 //
 // Mdfmt reads the named files, or else standard input, as Markdown documents
 // and then reprints the same Markdown documents to standard output.
 //
 // The -w flag specifies to rewrite the files in place.
+//
+// The -droplinks flag specifies to omit link reference definitions
+// that are never referenced (see [markdown.Printer.DropUnusedLinks]).
+//
+// The -lint flag specifies to report style problems instead of reformatting,
+// printing one line per problem, in the form file:line: message,
+// and exiting with status 1 if there are any.
+// The checks are for headings that skip levels or end in punctuation,
+// level-1 headings after the first,
+// reference links to undefined labels, table rows with a different
+// number of cells than the table header, and the corner cases noted by
+// [markdown.Parser.ParseCorners], which may render differently
+// in other Markdown implementations.
+// When linting, tables are parsed as in GitHub Flavored Markdown.
 package main
 
 import (
@@ -25,12 +39,14 @@ import (
 )
 
 var (
-	wflag = flag.Bool("w", false, "write reformatted Markdown back to input files")
-	exit  = 0
+	wflag    = flag.Bool("w", false, "write reformatted Markdown back to input files")
+	lintflag = flag.Bool("lint", false, "report style problems instead of reformatting")
+	dropflag = flag.Bool("droplinks", false, "omit unused link reference definitions")
+	exit     = 0
 )
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: mdfmt [-w] [file...]\n")
+	fmt.Fprintf(os.Stderr, "usage: mdfmt [-w] [-lint] [-droplinks] [file...]\n")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
@@ -62,9 +78,22 @@ func main() {
 }
 
 func convert(data []byte, file string) {
+	if *lintflag {
+		name := file
+		if name == "" {
+			name = "<standard input>"
+		}
+		for _, p := range lint(string(data)) {
+			fmt.Printf("%s:%d: %s\n", name, p.line, p.msg)
+			exit = 1
+		}
+		return
+	}
+
 	var p markdown.Parser
 	doc := p.Parse(string(data))
-	out := []byte(markdown.Format(doc))
+	pr := markdown.Printer{DropUnusedLinks: *dropflag}
+	out := []byte(pr.Format(doc))
 	if *wflag && file != "" {
 		if err := os.WriteFile(file, out, 0666); err != nil {
 			log.Print(err)
@@ -24,9 +24,80 @@ func (b *Empty) printHTML(p *printer) {}
 
 func (b *Empty) printMarkdown(*printer) {}
 
+// A Text is a [Block] holding inline content,
+// such as the text of a [Paragraph] or [Heading].
 type Text struct {
 	Position
 	Inline Inlines
+
+	// Raw is the text's unparsed inline content,
+	// set instead of Inline when [Parser.DeferInline] is set.
+	// (Inline may still hold a leading [Task], or an empty [Strong]
+	// for text demoted from a heading by [HeadingOverflowStrong].)
+	// Raw is empty once [Text.ParseInline] has been called.
+	Raw string
+}
+
+// ParseInline parses t.Raw, which was left unparsed
+// because [Parser.DeferInline] was set, and appends the result to t.Inline,
+// clearing t.Raw. For text demoted from a heading by [HeadingOverflowStrong],
+// it places the result in the empty [Strong] ending t.Inline instead.
+// The result is the same as if the inline content had been parsed
+// along with the rest of doc, which is the document
+// containing t: doc supplies the link reference definitions
+// and footnotes that references in t.Raw refer to.
+// The parser p should be the one that parsed doc.
+// ParseInline adds the labels of the references in t.Raw
+// to doc.UsedLinks.
+// ParseInline does nothing if t.Raw is empty.
+func (t *Text) ParseInline(p *Parser, doc *Document) {
+	if t.Raw == "" {
+		return
+	}
+	if p.StrictCommonMark {
+		p = p.strict()
+	}
+	ps := parser{Parser: p, links: doc.Links, usedLinks: doc.UsedLinks}
+	for _, note := range doc.Notes {
+		if ps.footnotes == nil {
+			ps.footnotes = make(map[string]*Footnote)
+		}
+		ps.footnotes[normalizeLabel(note.Label)] = note
+	}
+	ps.lineno = t.StartLine
+	inl := ps.inline(t.Raw)
+	if n := len(t.Inline); n > 0 {
+		// Text demoted from a heading by HeadingOverflowStrong
+		// holds an empty Strong to put the text in.
+		if s, ok := t.Inline[n-1].(*Strong); ok && len(s.Inner) == 0 {
+			s.Inner, inl = inl, nil
+		}
+	}
+	t.Inline = append(t.Inline, inl...)
+	t.Raw = ""
+	doc.UsedLinks = ps.usedLinks
+}
+
+// HTML returns the HTML for the inline content of t,
+// such as the text of a [Heading] without the surrounding <h1> tags,
+// using the default [Printer] settings.
+// See [Printer.TextHTML] for details.
+func (t *Text) HTML() string {
+	return new(Printer).TextHTML(t)
+}
+
+// TextHTML returns the HTML for the inline content of t,
+// with inline formatting like <code> and <em> preserved
+// but without any enclosing block tags.
+// For example, it can render the text of each [Heading]
+// in a document as the link text in a table of contents.
+// Unlike pr.ToHTML(t), TextHTML never appends a footnotes section,
+// even if t contains footnote references,
+// which are numbered as if t were the entire document.
+func (pr *Printer) TextHTML(t *Text) string {
+	p := printer{Printer: pr, writeMode: writeHTML}
+	t.printHTML(&p)
+	return p.buf.String()
 }
 
 // TODO: This is only a Block for tight lists. Maybe keep the Paragraphs for those?
@@ -39,13 +110,30 @@ func (b *Text) printHTML(p *printer) {
 }
 
 func (b *Text) printMarkdown(p *printer) {
-	for _, x := range b.Inline {
+	// Backticks in plain text can only come from backtick runs
+	// that failed to start a code span. A code span printed later
+	// might use a different number of backticks than in the input,
+	// which could then match those plain backticks, so escape them.
+	lastCode := -1
+	for i, x := range b.Inline {
+		if hasCode(x) {
+			lastCode = i
+		}
+	}
+	for i, x := range b.Inline {
+		p.escapeTicks = i < lastCode
+		if i == len(b.Inline)-1 {
+			p.lastInline = x
+		}
 		x.printMarkdown(p)
 	}
+	p.escapeTicks = false
+	p.lastInline = nil
 }
 
 // A Paragraph is a [Block] representing a [paragraph].
 // Except when they appear as top-level blocks in an item of a tight list,
+// or as top-level blocks in a document printed with [Printer.NoParagraphTags],
 // paragraphs render in <p>...</p> tags.
 //
 // [paragraph]: https://spec.commonmark.org/0.31.2/#paragraphs
@@ -57,14 +145,22 @@ type Paragraph struct {
 func (*Paragraph) Block() {}
 
 func (b *Paragraph) printHTML(p *printer) {
-	p.html("<p>")
+	if p.NoParagraphTags && p.topBlock == b {
+		b.Text.printHTML(p)
+		p.html("\n")
+		return
+	}
+	p.indentHTML()
+	p.html("<p", p.class("p"), ">")
 	b.Text.printHTML(p)
 	p.html("</p>\n")
 }
 
 func (b *Paragraph) printMarkdown(p *printer) {
 	p.maybeNL()
+	p.sentences = p.SentencePerLine
 	b.Text.printMarkdown(p)
+	p.sentences = false
 }
 
 // A paraBuilder is a [blockBuilder] for a [Paragraph].
@@ -149,6 +245,7 @@ func (b *paraBuilder) build(p *parser) Block {
 	// would need to do that too, which would complicate them.
 	// The join is simple.
 	s := strings.Join(b.text, "\n")
+	full := s
 
 	// Parse and remove any link reference definitions at the start of s.
 	for s != "" {
@@ -170,8 +267,12 @@ func (b *paraBuilder) build(p *parser) Block {
 	// might have been removed to start a table.
 	pos := p.pos()
 	pos.EndLine = pos.StartLine + len(b.text) - 1
+
+	// The text starts after any removed link reference definitions.
+	tpos := pos
+	tpos.StartLine += strings.Count(full[:len(full)-len(s)], "\n")
 	return &Paragraph{
 		pos,
-		p.newText(pos, s),
+		p.newText(tpos, s),
 	}
 }
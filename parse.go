@@ -5,7 +5,11 @@
 package markdown
 
 import (
+	"cmp"
+	"context"
+	"slices"
 	"strings"
+	"unicode/utf8"
 )
 
 type blockBuilder interface {
@@ -32,13 +36,14 @@ func (p *parser) deleteLast() {
 type rootBuilder struct{}
 
 func (b *rootBuilder) build(p *parser) Block {
-	return &Document{p.pos(), p.blocks(), p.links}
+	return &Document{p.pos(), p.blocks(), p.links, p.linkOrder, p.usedLinks, p.notes, p.noFinalNL}
 }
 
 // A Parser is a Markdown parser.
 // The exported fields in the struct can be filled in before calling
 // [Parser.Parse] in order to customize the details of the parsing process.
-// A Parser is safe for concurrent use by multiple goroutines.
+// A Parser is safe for concurrent use by multiple goroutines,
+// provided its HeadingIDFunc and AutoLinkScheme hooks (if any) are too.
 type Parser struct {
 	// HeadingID determines whether the parser accepts
 	// the {#hdr} syntax for an HTML id="hdr" attribute on headings.
@@ -48,11 +53,33 @@ type Parser struct {
 	//    <h2 id="overview">Overview</h2>
 	HeadingID bool
 
+	// HeadingIDFunc, if non-nil, validates and transforms the ids
+	// accepted by HeadingID, replacing the default, which accepts
+	// any id but records a [Corner] for ids that are not entirely
+	// ASCII letters and digits, as Goldmark requires.
+	// HeadingIDFunc is called with the id as written between {# and },
+	// with surrounding spaces removed, and returns the id to use.
+	// If it returns ok=false, the {#...} is not an id and remains
+	// part of the heading text.
+	// For example, a HeadingIDFunc could allow Unicode ids
+	// or transliterate them to match a site's anchor scheme.
+	HeadingIDFunc func(raw string) (id string, ok bool)
+
 	// Strikethrough determines whether the parser accepts
 	// ~abc~ and ~~abc~~ as strikethrough syntax, producing
 	// <del>abc</del> in HTML.
+	// As on GitHub, a single ~ works the same as ~~,
+	// although the opening and closing runs must have the same length,
+	// and runs of three or more ~ are not strikethrough syntax.
+	// (Some other implementations, including goldmark, accept only ~~.)
 	Strikethrough bool
 
+	// NoSingleTildeStrikethrough disables the single ~ form of
+	// Strikethrough, so that only ~~abc~~ is strikethrough syntax
+	// and a single ~ is literal text, leaving it free for
+	// other syntax, such as subscripts written H~2~O.
+	NoSingleTildeStrikethrough bool
+
 	// TaskList determines whether the parser accepts
 	// “task list items” as defined in GitHub Flavored Markdown.
 	// When a list item begins with the plain text [ ] or [x]
@@ -63,6 +90,36 @@ type Parser struct {
 	AutoLinkText       bool
 	AutoLinkAssumeHTTP bool
 
+	// AutoLinkMinimalTrim determines whether AutoLinkText trims
+	// less punctuation from the end of URL autolinks than GitHub does.
+	// GitHub removes any trailing ? ! . , : @ _ or ~ from the link,
+	// along with unbalanced closing parentheses and entity references.
+	// In minimal mode, a trailing @ _ or ~ is kept as part of the URL,
+	// so that a URL like https://example.com/file~ links as written,
+	// while sentence punctuation after a URL is still trimmed.
+	AutoLinkMinimalTrim bool
+
+	// AutoLinkScheme, if non-nil, chooses the scheme for
+	// www autolinks found by AutoLinkText, like www.example.com,
+	// which have no scheme of their own.
+	// It is called with the link's domain name, such as "www.example.com",
+	// and returns the scheme to use, such as "https" or "http".
+	// If it returns the empty string, the parser uses the default,
+	// which is https, or http if AutoLinkAssumeHTTP is set.
+	// Links that include a scheme, like http://example.com,
+	// are unaffected.
+	AutoLinkScheme func(host string) string
+
+	// AutoLinkStrictEmail determines whether AutoLinkText links
+	// email addresses only at word boundaries: the address must be
+	// at the start or end of the text or next to white space or
+	// punctuation, such as quotes, brackets, or . , ; : ! ?, other than
+	// the characters that can join an address to the surrounding text:
+	// # $ % & + - / = @ \ ^ ` { | }. Otherwise, AutoLinkText follows
+	// GitHub's behavior, which links the address in text like
+	// $abc@example.com (omitting the $) or abc@example.com/path.
+	AutoLinkStrictEmail bool
+
 	// TODO
 	Table bool
 
@@ -76,15 +133,361 @@ type Parser struct {
 
 	// TODO
 	Footnote bool
+
+	// ImageSize determines whether the parser accepts
+	// an image size =WxH after an inline image's destination,
+	// as in ![alt](img.png =200x100 "title"), storing the values
+	// in [Image.Width] and [Image.Height]. Either dimension
+	// can be omitted, as in =200x or =x100.
+	ImageSize bool
+
+	// Details determines whether the parser accepts
+	// ::: details and ::: spoiler blocks and >! spoilers,
+	// which render as HTML <details> elements.
+	// See [Details] for the syntax.
+	Details bool
+
+	// InlinePositions determines whether the parser records
+	// the input lines spanned by [Link], [Image], [AutoLink], and [Code]
+	// inlines in their Position fields.
+	// Otherwise, and for links created by [Parser.AutoLinkText],
+	// the Position fields are left zero.
+	InlinePositions bool
+
+	// DeferInline determines whether the parser skips parsing
+	// the inline content of paragraphs, headings, and table cells,
+	// leaving each [Text]'s Inline field empty and its Raw field
+	// holding the unparsed text, to be parsed later, if at all,
+	// by [Text.ParseInline]. This speeds up passes that need
+	// only the block structure, such as building an outline.
+	// Unlike the other fields, DeferInline does not change the syntax
+	// accepted, so it is honored even when StrictCommonMark is set.
+	DeferInline bool
+
+	// OnLink and OnImage, if non-nil, are called with each [Link]
+	// and [Image] the parser creates during inline parsing,
+	// once the link's text has been parsed, so that callers can
+	// check or rewrite URLs as the document is parsed, instead of
+	// walking the syntax tree afterward. For example, OnLink can
+	// record a diagnostic for each link to an unknown internal page,
+	// using the link's Position if InlinePositions is set.
+	// The callbacks may modify the link or image in place.
+	// OnLink is also called for the links created by AutoLinkText,
+	// but not for [AutoLink]s written in angle brackets.
+	// If text is parsed later, as with DeferInline, the callbacks
+	// are called by [Text.ParseInline] instead.
+	// Like DeferInline, the callbacks do not change the syntax accepted,
+	// so they are honored even when StrictCommonMark is set.
+	// If the Parser is used concurrently, OnLink and OnImage
+	// must be safe for concurrent use as well.
+	OnLink  func(*Link)
+	OnImage func(*Image)
+
+	// LaxHeadings determines whether the parser accepts
+	// ATX headings with no space after the opening #'s,
+	// such as #Heading, as found in some legacy content.
+	// This diverges from the CommonMark specification,
+	// which requires the space and treats #Heading as a paragraph.
+	// See https://spec.commonmark.org/0.31.2/#example-64.
+	LaxHeadings bool
+
+	// MaxHeadingLevel, if positive, is the deepest heading level
+	// the parser creates, for layouts that style only a few levels.
+	// Deeper headings, such as #### with MaxHeadingLevel 3,
+	// are handled according to HeadingOverflow.
+	// Zero means 6, the deepest level Markdown allows.
+	MaxHeadingLevel int
+
+	// HeadingOverflow determines how the parser handles headings
+	// deeper than MaxHeadingLevel. See [HeadingOverflow] for details.
+	HeadingOverflow HeadingOverflow
+
+	// NoIndentedCode determines whether the parser ignores
+	// indented code blocks, so that lines indented by four or more
+	// spaces are treated as ordinary text instead of code.
+	// Fenced code blocks are unaffected.
+	// This diverges from the CommonMark specification.
+	NoIndentedCode bool
+
+	// PreserveEntities determines whether the parser leaves
+	// HTML entity and numeric character references, like &amp; &#123; and &#x7B;,
+	// as literal text instead of decoding them,
+	// in text as well as in link destinations and titles and code block info strings.
+	// HTML output then escapes the leading &, as in &amp;amp;,
+	// so that the references display as written,
+	// and Markdown output reproduces them unchanged.
+	// This is useful for pipelines that decode entities later.
+	// It diverges from the CommonMark specification.
+	PreserveEntities bool
+
+	// QuoteCite determines whether the parser treats a bare
+	// http or https URL on the first line of a block quote
+	// as the quote's source, storing it in [Quote.Cite]
+	// instead of in the quote's text. For example,
+	//    > https://example.com/speech
+	//    > Four score and seven years ago...
+	// renders as
+	//    <blockquote cite="https://example.com/speech">
+	//    <p>Four score and seven years ago...</p>
+	//    </blockquote>
+	QuoteCite bool
+
+	// NoLinks, NoImages, NoAutoLinks, and NoInlineHTML determine
+	// whether the parser ignores the inline syntax for
+	// links (like [text](url) and [text][ref]),
+	// images (like ![alt](url)),
+	// autolinks (like <https://example.com>),
+	// and raw HTML tags (like <b>), respectively.
+	// Ignored syntax is treated as plain text, so that,
+	// for example, ![alt](url) renders literally when NoImages is set,
+	// instead of as a "!" followed by a link.
+	// They are meant for rendering restricted Markdown, such as comments,
+	// that must not contain certain elements.
+	// NoLinks does not affect link reference definitions, which are
+	// still removed from the text, nor links added by AutoLinkText,
+	// nor footnote references.
+	// NoInlineHTML does not affect HTML blocks.
+	// These diverge from the CommonMark specification.
+	NoLinks      bool
+	NoImages     bool
+	NoAutoLinks  bool
+	NoInlineHTML bool
+
+	// UnresolvedLinks determines how the parser handles a full or
+	// collapsed reference link, like [text][label] or [text][],
+	// whose label has no link reference definition.
+	// See [UnresolvedLinks] for details.
+	// Shortcut references like [text] are always left as plain text,
+	// since brackets are common in ordinary prose.
+	UnresolvedLinks UnresolvedLinks
+
+	// UnresolvedLinkURL is the URL of the placeholder links
+	// created when UnresolvedLinks is UnresolvedLinksPlaceholder.
+	UnresolvedLinkURL string
+
+	// NonBreakingSpace determines whether the parser treats
+	// a backslash followed by a space as a non-breaking space,
+	// rendered as &nbsp; in HTML and as U+00A0 in plain text,
+	// as in Pandoc. For example, "Mr.\ Smith" keeps "Mr." and "Smith"
+	// on the same line. The parser records the escape as an [Escaped]
+	// with Text " ", so that Format prints it as \ followed by a space.
+	// This diverges from the CommonMark specification,
+	// in which the backslash is literal text.
+	NonBreakingSpace bool
+
+	// HTMLBlockBlankLines determines whether an HTML block that
+	// would end at a blank line continues across blank lines
+	// as long as the next non-blank line starts with a <
+	// (after up to three spaces of indentation).
+	// This keeps raw HTML fragments with internal blank lines
+	// in a single [HTMLBlock] instead of splitting them into
+	// several blocks, possibly with Markdown in between.
+	// This diverges from the CommonMark specification.
+	HTMLBlockBlankLines bool
+
+	// TightListBlanks determines whether a single blank line
+	// inside a list item is ignored when deciding whether the list
+	// is loose, provided the blank line is only cosmetic:
+	// it must come before a nested list, a block quote,
+	// or a fenced code block, none of which need a blank line
+	// to separate them from the block before. For example,
+	//    - item
+	//
+	//      - nested item
+	//    - item
+	// is a tight list when TightListBlanks is set.
+	// Blank lines between list items, blank lines separating two
+	// paragraphs, and blank lines ending HTML blocks still make
+	// the list loose.
+	// This diverges from the CommonMark specification.
+	// See https://spec.commonmark.org/0.31.2/#loose.
+	TightListBlanks bool
+
+	// TabWidth is the width of the tab stops used when a tab
+	// appears in block indentation, such as the indentation of
+	// an indented code block or a list item continuation.
+	// If TabWidth is zero, the parser uses 4, as the CommonMark
+	// specification requires; other values are not spec-compliant.
+	// See https://spec.commonmark.org/0.31.2/#tabs.
+	TabWidth int
+
+	// MaxLinkParenDepth is the maximum nesting depth of the balanced
+	// parentheses allowed in an inline link destination without <>,
+	// as in [text](/a(b(c))). A destination nested more deeply is
+	// not a link at all. If MaxLinkParenDepth is zero, the parser uses 32,
+	// the same limit as cmark-gfm.
+	// The limit bounds the time spent on adversarial input with
+	// many unclosed parentheses, which is proportional to the input
+	// length times the limit, so larger values allow deeper
+	// nesting at the cost of slower worst-case parsing.
+	MaxLinkParenDepth int
+
+	// ExpandTabs determines whether the parser replaces every tab
+	// in the input with spaces up to the next tab stop (see TabWidth)
+	// before parsing, so that tabs inside code blocks and text
+	// render the same as the equivalent spaces.
+	// The md2html command sets ExpandTabs.
+	ExpandTabs bool
+
+	// ControlCharPolicy determines how the parser handles C0 control
+	// characters (U+0000 through U+001F) other than tab, newline,
+	// and carriage return, which can confuse programs
+	// consuming the HTML output of untrusted input.
+	// See [ControlCharPolicy] for details.
+	ControlCharPolicy ControlCharPolicy
+
+	// StrictCommonMark determines whether the parser ignores
+	// all the syntax extension fields above
+	// and accepts only the syntax defined in the CommonMark specification.
+	// It keeps the fields that control how the input is processed
+	// or reported rather than what syntax is accepted:
+	// InlinePositions, DeferInline, OnLink, OnImage, TabWidth,
+	// MaxLinkParenDepth, ExpandTabs, and ControlCharPolicy.
+	// It is a single switch for callers who need spec-only behavior,
+	// such as when comparing against other CommonMark implementations,
+	// and it applies equally to any extensions added in the future.
+	StrictCommonMark bool
+}
+
+// A ControlCharPolicy specifies how a [Parser] handles
+// C0 control characters in its input.
+// Any setting other than ControlCharKeep diverges from
+// the CommonMark specification, so the parser records a [Corner]
+// with reason "control character" at the first one it changes.
+type ControlCharPolicy int
+
+const (
+	// ControlCharKeep leaves control characters in the text,
+	// except that U+0000 is replaced by U+FFFD,
+	// as CommonMark requires.
+	ControlCharKeep ControlCharPolicy = iota
+
+	// ControlCharReplace replaces control characters by U+FFFD.
+	ControlCharReplace
+
+	// ControlCharStrip deletes control characters.
+	ControlCharStrip
+)
+
+// isControl reports whether c is a control character
+// handled by [Parser.ControlCharPolicy].
+func isControl(c byte) bool {
+	return c < ' ' && c != '\t' && c != '\n' && c != '\r'
+}
+
+// indexControl returns the index of the first control character in s,
+// or -1 if there is none.
+func indexControl(s string) int {
+	for i := 0; i < len(s); i++ {
+		if isControl(s[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// replaceControls returns s with its control characters
+// replaced or deleted according to policy.
+func replaceControls(s string, policy ControlCharPolicy) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !isControl(c) {
+			b.WriteByte(c)
+		} else if policy == ControlCharReplace {
+			b.WriteString("\uFFFD")
+		}
+	}
+	return b.String()
+}
+
+// lineAt returns the number of the line containing offset i in text.
+func lineAt(text string, i int) int {
+	before := text[:i]
+	return 1 + strings.Count(before, "\n") + strings.Count(before, "\r") - strings.Count(before, "\r\n")
+}
+
+// A HeadingOverflow specifies how a [Parser] handles headings
+// deeper than [Parser.MaxHeadingLevel].
+type HeadingOverflow int
+
+const (
+	// HeadingOverflowClamp creates a [Heading] at MaxHeadingLevel,
+	// so that with MaxHeadingLevel 3, #### Text is a level-3 heading.
+	HeadingOverflowClamp HeadingOverflow = iota
+
+	// HeadingOverflowStrong creates a [Paragraph] holding the
+	// heading text in a [Strong], so that with MaxHeadingLevel 3,
+	// #### Text renders as <p><strong>Text</strong></p>.
+	// An empty heading is dropped.
+	HeadingOverflowStrong
+)
+
+// An UnresolvedLinks specifies how a [Parser] handles
+// reference links whose labels have no definition.
+// Any setting other than UnresolvedLinksLiteral diverges from
+// the CommonMark specification, so the parser records a [Corner]
+// with reason "unresolved link reference" for each such link,
+// which [Parser.ParseCorners] callers can report as a diagnostic.
+type UnresolvedLinks int
+
+const (
+	// UnresolvedLinksLiteral leaves the link as plain text,
+	// brackets and label included, as CommonMark requires.
+	UnresolvedLinksLiteral UnresolvedLinks = iota
+
+	// UnresolvedLinksText keeps only the link text,
+	// dropping the brackets and the label,
+	// so that [text][missing] renders as text.
+	UnresolvedLinksText
+
+	// UnresolvedLinksPlaceholder turns the link into a [Link]
+	// to [Parser.UnresolvedLinkURL], such as "#broken-link",
+	// so that it can be styled or found in the output.
+	// Format prints the placeholder as an inline link.
+	UnresolvedLinksPlaceholder
+)
+
+// NewCommonMarkParser returns a new Parser that accepts only the syntax
+// defined in the CommonMark specification, with no extensions.
+// It sets StrictCommonMark, so extensions added to this package
+// in the future will stay disabled too.
+func NewCommonMarkParser() *Parser {
+	return &Parser{StrictCommonMark: true}
+}
+
+// NewGFMParser returns a new Parser for GitHub Flavored Markdown,
+// which is CommonMark plus the extensions defined in the
+// [GFM specification]: tables, task list items, strikethrough,
+// and extended autolinks. That is, it sets Table, TaskList,
+// Strikethrough, and AutoLinkText, and no other fields.
+//
+// The GFM specification also defines a “disallowed raw HTML” extension,
+// which filters tags like <script> and <iframe>. This package does not
+// implement it: raw HTML is passed through unchanged, and untrusted
+// output must be sanitized separately.
+// GitHub also renders footnotes, which the GFM specification does not
+// include; set the Footnote field to enable them as well.
+//
+// [GFM specification]: https://github.github.com/gfm/
+func NewGFMParser() *Parser {
+	return &Parser{
+		Table:         true,
+		TaskList:      true,
+		Strikethrough: true,
+		AutoLinkText:  true,
+	}
 }
 
 type parser struct {
 	*Parser
 
-	corner bool // noticed corner case to ignore in cross-implementation testing
+	corners []Corner // noticed corner cases to ignore in cross-implementation testing
 
 	root      *Document
 	links     map[string]*Link
+	linkOrder []string        // keys of links in definition order
+	usedLinks map[string]bool // keys of links referred to (Document.UsedLinks)
 	lineno    int
 	stack     []openBlock
 	lineDepth int
@@ -94,21 +497,62 @@ type parser struct {
 	texts []textRaw
 
 	footnotes map[string]*Footnote
+	notes     []*Footnote // footnotes in definition order
+	noFinalNL bool        // input does not end in a line ending (Document.NoFinalNewline)
 
 	// inline parsing
 	s       string
 	emitted int // s[:emitted] has been emitted into list
 	list    []Inline
+	nls     []int // offsets of newlines in s (Parser.InlinePositions)
 
 	backticks backtickParser
 
 	fixups []func()
+
+	ctx context.Context // context to check during parsing; nil for none
+	err error           // ctx.Err() once the inline parser has noticed it
 }
 
 func (p *parser) addFixup(f func()) {
 	p.fixups = append(p.fixups, f)
 }
 
+// A Corner is a corner case noticed during parsing:
+// input that this package is known to parse differently
+// from other Markdown implementations, notably Goldmark.
+// Documents with no corner cases should render the same
+// in this package as in those other implementations.
+type Corner struct {
+	// Line is the input line number where the corner case was noticed.
+	// For corner cases in inline syntax, such as links or emphasis,
+	// Line is the first line of the enclosing block.
+	Line int
+
+	// Reason is a short description of the corner case.
+	// Each kind of corner case has a different, unchanging Reason,
+	// although a few kinds are detected in more than one place,
+	// such as the start and continuation lines of an indented code block.
+	Reason string
+}
+
+// noteCorner records a corner case with the given reason at the current line.
+func (p *parser) noteCorner(reason string) {
+	p.cornerAt(p.lineno, reason)
+}
+
+// cornerAt records a corner case with the given reason at the given line.
+// Repeated reports of the same corner case on the same line are recorded once.
+func (p *parser) cornerAt(line int, reason string) {
+	c := Corner{line, reason}
+	for i := len(p.corners) - 1; i >= 0 && p.corners[i].Line == line; i-- {
+		if p.corners[i] == c {
+			return
+		}
+	}
+	p.corners = append(p.corners, c)
+}
+
 type lineInfo struct {
 	noDeclEnd     bool // no > on line
 	noCommentEnd  bool // no --> on line
@@ -137,19 +581,149 @@ func (p *parser) pos() Position {
 	return b.pos
 }
 
+// Parse parses text as a Markdown document and returns the syntax tree.
+// As in the CommonMark specification, a line ends with \n, \r\n, or a lone \r,
+// and the three can be mixed in a single text.
+// Line numbers in the tree count all three kinds of line endings,
+// and multi-line text in the tree, such as code block content,
+// always uses \n, as does the output of [ToHTML] and [Format].
 func (p *Parser) Parse(text string) *Document {
 	d, _ := p.parse(text)
 	return d
 }
 
-func (p *Parser) parse(text string) (d *Document, corner bool) {
+// HasMarkdown reports whether text might contain Markdown syntax
+// accepted by the zero [Parser], which parses only CommonMark.
+// If HasMarkdown returns false, text is plain prose:
+// parsing it yields only paragraphs of plain text,
+// separated by blank lines, so callers rendering large volumes of
+// short messages can skip parsing and simply HTML-escape the text.
+// HasMarkdown is conservative: it returns true for any text
+// containing characters that can start Markdown syntax,
+// such as * _ ` [ < & or \, or lines starting with block syntax,
+// such as # or - or 1. or four spaces of indentation,
+// even when the parser would treat them as plain text.
+// Parsers with extensions enabled, such as Strikethrough or AutoLinkText,
+// accept syntax that HasMarkdown does not check for.
+func HasMarkdown(text string) bool {
+	lineStart := true
+	for i := 0; i < len(text); i++ {
+		if lineStart {
+			lineStart = false
+			j := i
+			for j < len(text) && j-i < 4 && text[j] == ' ' {
+				j++
+			}
+			if j-i == 4 {
+				return true // indented code
+			}
+			if j < len(text) {
+				switch c := text[j]; c {
+				case '-', '+', '=', '#', '>', '~', '\t':
+					return true // list, heading, quote, fence, or indentation
+				default:
+					k := j
+					for k < len(text) && isDigit(text[k]) {
+						k++
+					}
+					if k > j && k < len(text) && (text[k] == '.' || text[k] == ')') {
+						return true // ordered list
+					}
+				}
+			}
+		}
+		switch text[i] {
+		case '\\', '`', '*', '_', '[', ']', '<', '&', '\x00':
+			return true
+		case '\n', '\r':
+			if i >= 2 && text[i-1] == ' ' && text[i-2] == ' ' {
+				return true // hard line break
+			}
+			lineStart = true
+		}
+	}
+	return false
+}
+
+// ParseCorners is like [Parser.Parse] but also returns
+// the corner cases noticed during parsing, sorted by line number.
+// This can be used to find documents that might render differently
+// in this package than in other Markdown implementations.
+func (p *Parser) ParseCorners(text string) (*Document, []Corner) {
+	return p.parse(text)
+}
+
+// ParseContext is like [Parser.Parse] but stops parsing early
+// if ctx is canceled or its deadline passes, returning ctx.Err().
+// It checks ctx periodically as it processes the lines of text
+// and again as it processes the inline content of each block,
+// including within a single large block, which bounds the time spent on large adversarial inputs.
+func (p *Parser) ParseContext(ctx context.Context, text string) (*Document, error) {
+	d, _, err := p.parseContext(ctx, text)
+	return d, err
+}
+
+// strict returns the Parser to use in place of p when p.StrictCommonMark is set:
+// one with every syntax extension disabled but the fields that do not
+// change the accepted syntax copied from p.
+func (p *Parser) strict() *Parser {
+	return &Parser{
+		StrictCommonMark:  true,
+		InlinePositions:   p.InlinePositions,
+		DeferInline:       p.DeferInline,
+		OnLink:            p.OnLink,
+		OnImage:           p.OnImage,
+		TabWidth:          p.TabWidth,
+		MaxLinkParenDepth: p.MaxLinkParenDepth,
+		ExpandTabs:        p.ExpandTabs,
+		ControlCharPolicy: p.ControlCharPolicy,
+	}
+}
+
+// ctxCheckLines is the number of lines parseContext processes
+// between checks of its context.
+const ctxCheckLines = 256
+
+// ctxCheckInline is the number of input bytes the inline parser
+// processes between checks of its context.
+const ctxCheckInline = 4096
+
+// canceled reports whether p's context is done,
+// recording the context's error in p.err.
+func (p *parser) canceled() bool {
+	if p.err == nil && p.ctx != nil {
+		p.err = p.ctx.Err()
+	}
+	return p.err != nil
+}
+
+func (p *Parser) parse(text string) (d *Document, corners []Corner) {
+	d, corners, _ = p.parseContext(context.Background(), text)
+	return d, corners
+}
+
+func (p *Parser) parseContext(ctx context.Context, text string) (d *Document, corners []Corner, err error) {
+	if p.StrictCommonMark {
+		// Parse with every extension disabled.
+		p = p.strict()
+	}
+
 	var ps parser
 	ps.Parser = p
-	if strings.Contains(text, "\x00") {
+	ps.ctx = ctx
+	if p.ControlCharPolicy != ControlCharKeep {
+		if i := indexControl(text); i >= 0 {
+			text = replaceControls(text, p.ControlCharPolicy)
+			ps.cornerAt(lineAt(text, i), "control character") // goldmark keeps control characters
+		}
+	}
+	if i := strings.Index(text, "\x00"); i >= 0 {
 		text = strings.ReplaceAll(text, "\x00", "\uFFFD")
-		ps.corner = true // goldmark does not replace NUL
+		ps.cornerAt(lineAt(text, i), "NUL byte") // goldmark does not replace NUL
 	}
 
+	ps.noFinalNL = text != "" && text[len(text)-1] != '\n' && text[len(text)-1] != '\r'
+
 	ps.lineDepth = -1
 	ps.addBlock(&rootBuilder{})
 	for text != "" {
@@ -168,19 +742,42 @@ func (p *Parser) parse(text string) (d *Document, corner bool) {
 			nl = text[0]
 			text = text[1:]
 		}
+		if p.ExpandTabs {
+			ln = expandTabs(ln, p.TabWidth)
+		}
 		ps.lineno++
-		ps.addLine(makeLine(ln, nl))
+		if ps.lineno%ctxCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+		}
+		ps.addLine(makeLine(ln, nl, p.TabWidth))
 	}
 	ps.trimStack(0)
 
 	for _, t := range ps.texts {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		if p.DeferInline {
+			t.Raw = t.raw
+			continue
+		}
+		ps.lineno = t.StartLine // for noteCorner
 		t.Inline = ps.inline(t.raw)
+		if ps.err != nil {
+			return nil, nil, ps.err
+		}
 	}
 
 	for _, f := range ps.fixups {
 		f()
 	}
 
+	// The root was built before the inline parsing
+	// that records which links are used.
+	ps.root.UsedLinks = ps.usedLinks
+
 	// TODO move into its own function
 	var fixBlock func(Block)
 
@@ -208,12 +805,51 @@ func (p *Parser) parse(text string) (d *Document, corner bool) {
 			}
 		case *Item:
 			x.Blocks = fixBlocks(x.Blocks)
+		case *Details:
+			x.Blocks = fixBlocks(x.Blocks)
 		}
 	}
 
 	fixBlock(ps.root)
 
-	return ps.root, ps.corner
+	// Inline corner cases are noticed after all block corner cases.
+	// Sort into line order.
+	slices.SortStableFunc(ps.corners, func(x, y Corner) int {
+		return cmp.Compare(x.Line, y.Line)
+	})
+
+	return ps.root, ps.corners, nil
+}
+
+// expandTabs returns s with each tab replaced by spaces
+// up to the next multiple of width columns (4 if width is zero).
+// Each rune counts as a single column.
+func expandTabs(s string, width int) string {
+	if !strings.Contains(s, "\t") {
+		return s
+	}
+	if width <= 0 {
+		width = 4
+	}
+	var b strings.Builder
+	col := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\t' {
+			b.WriteByte(' ')
+			col++
+			for col%width != 0 {
+				b.WriteByte(' ')
+				col++
+			}
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteString(s[i : i+size])
+		col++
+		i += size
+	}
+	return b.String()
 }
 
 func (p *parser) curB() blockBuilder {
@@ -282,11 +918,20 @@ func (p *parser) link(label string) *Link {
 	return p.links[label]
 }
 
+// useLink records that the link with the given key was referred to.
+func (p *parser) useLink(key string) {
+	if p.usedLinks == nil {
+		p.usedLinks = make(map[string]bool)
+	}
+	p.usedLinks[key] = true
+}
+
 func (p *parser) defineLink(label string, link *Link) {
 	if p.links == nil {
 		p.links = make(map[string]*Link)
 	}
 	p.links[label] = link
+	p.linkOrder = append(p.linkOrder, label)
 }
 
 func (p *parser) addLine(s line) {
@@ -336,9 +981,19 @@ func (c *rootBuilder) extend(p *parser, s line) (line, bool) {
 
 type starter func(*parser, line) (line, bool)
 
+// starters lists the block starters in the order they are tried.
+// The order matters for lines of dashes:
+// startSetextHeading must come before startThematicBreak,
+// so that --- after a paragraph is a setext underline,
+// and startThematicBreak must come before startListItem,
+// so that - - - is a thematic break and not a list item.
+// See testdata/setext_break.txt.
+// Similarly, startSpoiler must come before startBlockQuote,
+// so that >! starts a spoiler and not a block quote.
 var starters = []starter{
 	startIndentedCodeBlock,
 	startFencedCodeBlock,
+	startSpoiler,
 	startBlockQuote,
 	startATXHeading,
 	startSetextHeading,
@@ -346,4 +1001,5 @@ var starters = []starter{
 	startListItem,
 	startHTMLBlock,
 	startFootnote,
+	startDetails,
 }
@@ -4,7 +4,14 @@
 
 package markdown
 
-import "bytes"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
 
 const (
 	writeMarkdown = iota
@@ -12,23 +19,484 @@ const (
 	writeText
 )
 
+// A Printer prints Markdown syntax trees as HTML or Markdown.
+// The exported fields in the struct can be filled in before calling
+// [Printer.ToHTML] or [Printer.Format] in order to customize the output.
+// The zero Printer prints the same output as [ToHTML] and [Format].
+// A Printer is safe for concurrent use by multiple goroutines,
+// provided its BlockHTML, CodeBlockHTML, and ImageData hooks (if any) are too.
+type Printer struct {
+	// Sections determines whether HTML output wraps each heading
+	// in a document, along with the blocks following it, in a <section> element.
+	// A section ends at the next heading of the same or higher level
+	// (same or smaller Level) or at the end of the document,
+	// so sections nest according to heading levels.
+	// Skipped levels do not create extra sections: in a document with
+	// an h1 followed by an h3, the h3 section is directly inside the h1 section.
+	Sections bool
+
+	// NoParagraphTags determines whether HTML output omits the
+	// <p> and </p> tags around top-level paragraphs, as is done for
+	// paragraphs in tight list items. Each such paragraph is printed
+	// as its inline content followed by a newline.
+	// Paragraphs nested in other blocks, such as block quotes
+	// and list items, are unaffected.
+	NoParagraphTags bool
+
+	// MinimalDiff determines whether Markdown output preserves
+	// details of the original input layout that are recorded in the
+	// syntax tree but that Format would otherwise normalize,
+	// to keep the differences between input and output small.
+	// Currently, it preserves the spacing before and after
+	// list item markers (see [Item]), unless ListMarkerSpaces is set,
+	// the closing #s of ATX headings (see [Heading]),
+	// and the raw indentation of indented code blocks (see [CodeBlock]).
+	MinimalDiff bool
+
+	// ListMarkerSpaces is the number of spaces Markdown output
+	// prints after each list item marker, up to a maximum of 4.
+	// If ListMarkerSpaces is zero, Format prints a single space.
+	ListMarkerSpaces int
+
+	// FinalNewline determines how Markdown output for a [Document] ends.
+	// See [FinalNewline] for details.
+	FinalNewline FinalNewline
+
+	// PlainDel determines how [Printer.PlainText] writes
+	// strikethrough ([Del]) text.
+	// See [PlainDel] for details.
+	PlainDel PlainDel
+
+	// Escapes, if non-nil, overrides the sets of punctuation
+	// characters that Markdown output escapes with backslashes.
+	// See [EscapeSet] for details.
+	Escapes *EscapeSet
+
+	// ListSpacing overrides whether lists are printed loose or tight.
+	// See [ListSpacing] for details.
+	ListSpacing ListSpacing
+
+	// AlwaysWrapListParagraphs determines whether HTML output
+	// wraps the text of items in tight lists in <p> tags,
+	// as is done for loose lists, for consistent styling.
+	// It takes precedence over ListSpacingTight in HTML output,
+	// but it does not make tight lists loose in Markdown output.
+	AlwaysWrapListParagraphs bool
+
+	// OrderedListDelimiter, if '.' or ')', is the delimiter Markdown output
+	// prints after the numbers of ordered list items, replacing the
+	// delimiter recorded in each [List]'s Bullet field.
+	// Because a change of delimiter starts a new list, an ordered list
+	// that immediately follows another ordered list uses the other
+	// delimiter, so that the two lists are not merged into one.
+	// Other values, including zero, leave delimiters unchanged.
+	OrderedListDelimiter rune
+
+	// BaseURL, if non-empty, is a URL or path against which
+	// HTML output resolves the relative URLs of links, images,
+	// and autolinks, following RFC 3986. Absolute URLs and
+	// fragment-only references like #section are left alone,
+	// as are all URLs when BaseURL itself cannot be parsed.
+	// For example, with BaseURL "/docs/guide/", the link
+	// destination "../img/x.png" renders as "/docs/img/x.png".
+	BaseURL string
+
+	// EscapeInvalidPercent determines whether HTML output rewrites
+	// each % in the URLs of links, images, and autolinks that does not
+	// start a valid %XX escape as %25, as Goldmark and the CommonMark
+	// reference implementation do. Valid %XX escapes are left alone.
+	EscapeInvalidPercent bool
+
+	// ImageLazyLoading and ImageAsyncDecoding determine whether
+	// HTML output adds loading="lazy" and decoding="async" attributes,
+	// respectively, to every <img> tag.
+	ImageLazyLoading   bool
+	ImageAsyncDecoding bool
+
+	// ImageData, if non-nil, is called with the URL of each image
+	// printed as HTML, after resolution against BaseURL.
+	// If it returns ok=true, the <img> tag's src attribute is
+	// a data: URI holding the returned MIME type and data, base64-encoded,
+	// so that the HTML is self-contained, as is useful in email.
+	// Otherwise the src attribute is the URL, as usual.
+	// The package never fetches images itself: ImageData decides
+	// which URLs to load and how.
+	ImageData func(url string) (mimeType string, data []byte, ok bool)
+
+	// FootnoteTitles determines whether HTML output adds to each
+	// footnote reference a title attribute holding the plain text
+	// of the first paragraph of the footnote, so that browsers show
+	// the footnote when the pointer hovers over the reference.
+	FootnoteTitles bool
+
+	// FootnotePopovers determines whether HTML output follows
+	// each footnote reference with a hidden copy of the footnote,
+	// in a <span class="fn-content" hidden> element, so that
+	// style sheets can show the footnote at the reference point,
+	// such as in a CSS-only popover. Because a span can hold only
+	// inline content, the copy holds the text of the footnote's
+	// paragraphs, separated by spaces, omitting its other blocks.
+	// Footnote references in the copy have no id attributes
+	// and do not affect footnote numbering, so the footnotes section
+	// at the end of the document is unchanged; a reference to
+	// a footnote that has not been numbered yet is printed as its label.
+	FootnotePopovers bool
+
+	// ARIA determines whether HTML output adds ARIA roles and labels,
+	// for assistive technologies such as screen readers, to the
+	// structures the printer generates rather than copies from the input:
+	// role="doc-noteref" on footnote references;
+	// role="doc-endnotes" and aria-label="Footnotes" on the footnote list;
+	// role="doc-backlink" and an aria-label like "Back to reference 1"
+	// on the links back from footnotes to their references;
+	// an aria-label of "Completed task" or "Incomplete task"
+	// on task list checkboxes; and scope="col" on table header cells.
+	ARIA bool
+
+	// EscapeNonASCII determines whether HTML output writes
+	// every non-ASCII character in document text, including
+	// code spans, code blocks, headings, image alt text, and link titles,
+	// as a numeric character reference like &#xE9;
+	// instead of as UTF-8, for pipelines that require ASCII-only HTML.
+	// Invalid UTF-8 is written as &#xFFFD;.
+	// Raw HTML is unaffected, and link URLs are already percent-encoded.
+	EscapeNonASCII bool
+
+	// MaxOutputBytes, if positive, is the maximum length of the
+	// output, which bounds the resources used to render untrusted input.
+	// Output that would be longer is an error: see [Printer.TryToHTML]
+	// and [Printer.TryFormat].
+	MaxOutputBytes int
+
+	// SentencePerLine determines whether Markdown output starts a new line
+	// after each sentence in paragraph text, a style sometimes called
+	// semantic line breaks, which makes diffs of prose easier to read.
+	// A sentence ends at a . ! or ? followed by a space and an upper-case
+	// letter, so abbreviations such as "Mr. Smith" also end a line.
+	// Existing line breaks are kept. The text of code spans, links, and
+	// images is never split, nor is text in headings and table cells.
+	SentencePerLine bool
+
+	// MergeThematicBreaks determines whether Markdown output
+	// prints a run of consecutive thematic breaks as a single break,
+	// cleaning up the stacked breaks found in some generated content.
+	// Breaks separated by any other block, even a blank HTML block,
+	// are not merged.
+	MergeThematicBreaks bool
+
+	// DropUnusedLinks determines whether Markdown output omits
+	// link reference definitions that no reference link or image
+	// in the document refers to, as recorded in [Document.UsedLinks].
+	// Duplicate definitions never appear in the output:
+	// the parser keeps only the first definition of each label,
+	// and Format prints the definitions sorted by normalized label.
+	DropUnusedLinks bool
+
+	// TrimCodeTrailingSpace determines whether Markdown output
+	// removes trailing spaces and tabs from the lines of code blocks.
+	// The indentation of indented code blocks is kept,
+	// except on lines that become blank.
+	TrimCodeTrailingSpace bool
+
+	// CompactTables determines whether Markdown output prints
+	// table cells with a single space of padding, as in | a | b |,
+	// instead of padding cells to align the columns.
+	// Compact tables are harder to read in source form,
+	// but changing one cell does not change the other rows.
+	CompactTables bool
+
+	// SingleH1 determines whether HTML and Markdown output
+	// print every level-1 heading after the first one
+	// as a level-2 heading, so that the output has at most one <h1>,
+	// as accessibility and search engine guidelines recommend.
+	// It changes the document structure, so it is off by default.
+	// (The mdfmt -lint command reports multiple level-1 headings.)
+	SingleH1 bool
+
+	// HTMLIndent, if non-empty, is printed at the start of each line
+	// of HTML output that begins with a block-level tag,
+	// once for each enclosing block, such as a list item or block quote,
+	// so that the nesting of the output is easier to see when debugging.
+	// For example, with HTMLIndent "  ", a list item prints as "  <li>".
+	// The indentation is only added before tags that already start a line,
+	// never inside paragraph text, code blocks, or raw HTML blocks,
+	// so it does not change how the HTML renders.
+	HTMLIndent string
+
+	// CodeLineNumbers determines whether HTML output wraps each line
+	// of a code block in a numbered span, such as
+	// <span class="line" data-line="1">...</span>, so that style sheets
+	// can display line numbers, for example using CSS counters
+	// or attr(data-line). Every line is numbered, including blank lines,
+	// and the text of each line is printed unchanged, tabs included.
+	CodeLineNumbers bool
+
+	// ClassPrefix, if non-empty, causes HTML output to add a class
+	// attribute naming the prefix and the element, such as
+	// class="md-p" for ClassPrefix "md", to the opening tags of the
+	// elements printed for blocks: p, h1 through h6, pre, blockquote,
+	// ul, ol, li, hr, table, and details.
+	// Raw HTML blocks are left alone.
+	ClassPrefix string
+
+	// BlockHTML, if non-nil, is called after each top-level block
+	// of a document is rendered as HTML, with the block and its HTML.
+	// The result replaces the block's HTML in the output,
+	// making it possible to wrap or annotate individual blocks,
+	// such as to add a copy button to code blocks.
+	// BlockHTML is not called for blocks nested inside other blocks.
+	// If the Printer is used concurrently, BlockHTML must be safe
+	// for concurrent use as well.
+	BlockHTML func(b Block, html string) string
+
+	// CodeBlockHTML, if non-nil, is called after each code block,
+	// including code blocks nested inside other blocks,
+	// is rendered as HTML, with the block and its HTML.
+	// The result replaces the block's HTML in the output.
+	// The block's Info field holds the full info string,
+	// such as go title="main.go", of which the default HTML
+	// uses only the first word, in the language- class,
+	// so CodeBlockHTML can use the rest, for example
+	// to add a file name above the code.
+	// For a top-level code block, BlockHTML (if any) is called
+	// with the result of CodeBlockHTML.
+	// If the Printer is used concurrently, CodeBlockHTML must be safe
+	// for concurrent use as well.
+	CodeBlockHTML func(b *CodeBlock, html string) string
+}
+
+// A ListSpacing specifies how a [Printer] prints loose and tight lists.
+// (See the [List] doc comment for the difference.)
+type ListSpacing int
+
+const (
+	// ListSpacingAuto prints each list as loose or tight
+	// according to its Loose field.
+	ListSpacingAuto ListSpacing = iota
+
+	// ListSpacingLoose prints every list as loose.
+	ListSpacingLoose
+
+	// ListSpacingTight prints every list as tight,
+	// except lists that cannot be tight: those with an item
+	// containing blocks other than a single paragraph followed by
+	// nested lists, such as an item with two paragraphs.
+	// Those lists are printed according to their Loose fields.
+	ListSpacingTight
+)
+
+// A FinalNewline specifies how a [Printer] ends the Markdown output
+// for a [Document]. Output for an empty document is always empty.
+type FinalNewline int
+
+const (
+	// FinalNewlineSingle ends the output with exactly one newline.
+	FinalNewlineSingle FinalNewline = iota
+
+	// FinalNewlineNone ends the output without a newline.
+	FinalNewlineNone
+
+	// FinalNewlinePreserve ends the output with a newline
+	// unless the document's input did not end with one,
+	// as recorded in [Document.NoFinalNewline].
+	FinalNewlinePreserve
+)
+
+// A PlainDel specifies how a [Printer] writes strikethrough text
+// in plain text output. Image alt text in HTML output
+// always omits the strikethrough.
+type PlainDel int
+
+const (
+	// PlainDelText writes just the text, dropping the strikethrough.
+	PlainDelText PlainDel = iota
+
+	// PlainDelMarkers writes the text surrounded by its
+	// Markdown markers, as in ~~text~~.
+	PlainDelMarkers
+
+	// PlainDelCombining writes the text with a Unicode
+	// combining long stroke overlay (U+0336) after each
+	// non-space character, as in t̶e̶x̶t̶, which many terminals
+	// and mail readers display as struck through.
+	PlainDelCombining
+)
+
+// An EscapeSet lists the ASCII punctuation characters that
+// Markdown output escapes with backslashes in various contexts,
+// for use with downstream parsers that are stricter or looser
+// than CommonMark about which characters need escaping.
+// Removing characters from the default sets can produce Markdown
+// that does not parse back into the same syntax tree.
+type EscapeSet struct {
+	// Text lists the characters to escape in plain text.
+	// The default is none, because the parser records
+	// escaped characters as [Escaped] inlines,
+	// so the plain text of a parsed document never needs escaping.
+	// (Backticks are escaped as needed regardless.)
+	Text string
+
+	// URL lists the characters to escape in inline
+	// link and image destinations. The default is DefaultURLEscapes.
+	URL string
+
+	// Title lists the characters to escape in link and image titles,
+	// in addition to backslashes and the title's closing quote,
+	// which are always escaped. The default is DefaultTitleEscapes.
+	Title string
+}
+
+// Default escape sets for Markdown output (see [EscapeSet]).
+const (
+	DefaultURLEscapes   = "()<>"
+	DefaultTitleEscapes = "()[]*_<>"
+)
+
+// escapes returns the escape sets for Markdown output.
+func (p *printer) escapes() *EscapeSet {
+	if p.Escapes != nil {
+		return p.Escapes
+	}
+	return &defaultEscapes
+}
+
+var defaultEscapes = EscapeSet{URL: DefaultURLEscapes, Title: DefaultTitleEscapes}
+
+// EscapeText returns s escaped for use as plain text in Markdown,
+// so that the result, inserted into a paragraph, heading,
+// or table cell, renders as s, without any emphasis, links, code spans,
+// raw HTML, or other syntax. For example, EscapeText("*Hi* [x]")
+// returns `\*Hi\* \[x\]`.
+// The result is suitable for building documents from strings,
+// such as names or titles, that may contain Markdown metacharacters.
+//
+// Lines in s end at \n, \r\n, or \r, and each line is escaped separately.
+// EscapeText removes spaces and tabs at the start and end of each line,
+// since Markdown does not preserve them, and it escapes characters that
+// would begin block syntax at the start of a line, such as # or - or 1.
+// Blank lines still separate paragraphs.
+// EscapeText also escapes the syntax of the table, strikethrough,
+// footnote, and details extensions (see [Parser]),
+// but not the extensions that rewrite plain text,
+// such as AutoLinkText, Emoji, and SmartQuote.
+func EscapeText(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		line = escapeAmp(escapePunct(trimSpaceTab(line), textEscapes))
+		if line != "" && strings.IndexByte(lineStartEscapes, line[0]) >= 0 {
+			line = `\` + line
+		}
+		j := 0
+		for j < len(line) && isDigit(line[j]) {
+			j++
+		}
+		if j > 0 && j < len(line) && (line[j] == '.' || line[j] == ')') {
+			line = line[:j] + `\` + line[j:]
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+const (
+	// textEscapes lists the characters EscapeText always escapes.
+	textEscapes = "\\`*_[]<~|"
+
+	// lineStartEscapes lists the characters EscapeText escapes
+	// at the start of a line, where they can begin block syntax.
+	lineStartEscapes = "#-+=>:"
+)
+
+// EscapeURL returns url escaped for use as a link or image destination
+// in Markdown, as in [text](dest), so that the result parses back as url.
+// It uses the same escaping as [Printer.Format] with the default [EscapeSet],
+// backslash-escaping the characters in DefaultURLEscapes,
+// along with backslashes and & characters that would otherwise
+// be read as escapes or entity references, and enclosing the result
+// in angle brackets if url is empty or contains spaces or control characters.
+// Line endings cannot appear in a destination, so EscapeURL
+// percent-encodes \n and \r as %0A and %0D.
+func EscapeURL(url string) string {
+	return escapeURL(url, DefaultURLEscapes)
+}
+
+// escapeURL returns url escaped for use as a link destination,
+// backslash-escaping the characters in set.
+// See [EscapeURL] for details.
+func escapeURL(url, set string) string {
+	url = strings.ReplaceAll(url, "\n", "%0A")
+	url = strings.ReplaceAll(url, "\r", "%0D")
+	if strings.Contains(url, `\`) {
+		var b strings.Builder
+		for i := 0; i < len(url); i++ {
+			// A backslash is literal unless it precedes punctuation,
+			// including the ) or > that ends the destination.
+			if url[i] == '\\' && (i+1 == len(url) || isPunct(url[i+1])) {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(url[i])
+		}
+		url = b.String()
+	}
+	url = escapeAmp(escapePunct(url, set))
+	if url == "" || strings.IndexFunc(url, func(r rune) bool { return r <= ' ' || r == 0x7f }) >= 0 {
+		url = "<" + url + ">"
+	}
+	return url
+}
+
+// escapeAmp returns s with a backslash inserted before each &
+// that begins an HTML entity or numeric character reference,
+// like &amp; or &#123;.
+func escapeAmp(s string) string {
+	if !strings.Contains(s, "&") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '&' {
+			if _, _, ok := parseHTMLEntity(nil, s, i); ok {
+				b.WriteByte('\\')
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
 type printer struct {
+	*Printer
+
 	writeMode   int
 	buf         bytes.Buffer
 	prefix      []byte
 	prefixOld   []byte
 	prefixOlder []byte
 	trimLimit   int
+	escapeTicks bool   // escape backticks in Plain text (Markdown only)
+	sentences   bool   // split Plain text into sentences (Printer.SentencePerLine)
+	sawH1       bool   // printed a level-1 heading (Printer.SingleH1)
+	lastInline  Inline // final inline of Text being printed (Markdown only)
+	depth       int    // nesting depth of HTML blocks (Printer.HTMLIndent)
+	topBlock    Block  // top-level document block being printed (HTML only)
+	base        *url.URL
+	baseErr     bool
 	listOut
+	lastDelim    rune // delimiter of preceding sibling ordered list, or 0
 	footnotes    map[*Footnote]*printedNote
 	footnotelist []*printedNote
+	inPopover    bool             // printing footnote popover content (Printer.FootnotePopovers)
+	links        map[string]*Link // link reference definitions of document (Markdown only)
 }
 
 type listOut struct {
-	bullet rune
-	num    int
-	loose  int
-	tight  int
+	bullet  rune
+	num     int
+	loose   int
+	tight   int
+	spacing ListSpacing // spacing of current list items, if overridden
 }
 
 func (w *printer) WriteStrings(list ...string) {
@@ -58,6 +526,7 @@ func (b *printer) nl() {
 
 	b.buf.WriteByte('\n')
 	b.buf.Write(b.prefix)
+	b.checkSize()
 	b.prefixOlder, b.prefixOld = b.prefixOld, b.prefix
 }
 
@@ -81,20 +550,389 @@ func (b *printer) maybeNL() bool {
 	return true
 }
 
+// ToHTML returns the HTML for b, using the default [Printer] settings.
 func ToHTML(b Block) string {
-	var p printer
+	return new(Printer).ToHTML(b)
+}
+
+// TryToHTML is like [ToHTML] but returns an error
+// instead of panicking if b is not a valid syntax tree.
+// See [Printer.TryToHTML].
+func TryToHTML(b Block) (string, error) {
+	return new(Printer).TryToHTML(b)
+}
+
+// ToHTML returns the HTML for b.
+// If the HTML would be longer than pr.MaxOutputBytes,
+// ToHTML returns an empty string; use [Printer.TryToHTML]
+// to distinguish that case.
+// ToHTML panics if b is not a valid syntax tree.
+func (pr *Printer) ToHTML(b Block) string {
+	html, _ := pr.toHTML(b, false)
+	return html
+}
+
+// TryToHTML is like [Printer.ToHTML] but returns
+// an empty string and [ErrOutputTooLarge] if the HTML
+// would be longer than pr.MaxOutputBytes.
+// If printing finds that b is not a valid syntax tree,
+// TryToHTML returns an empty string and an error wrapping [ErrInvalidTree].
+// Other malformed trees, such as ones containing nil blocks,
+// cause runtime panics as in ToHTML.
+func (pr *Printer) TryToHTML(b Block) (html string, err error) {
+	return pr.toHTML(b, true)
+}
+
+// toHTML implements [Printer.ToHTML] and [Printer.TryToHTML].
+// If try is set, an invalid tree is reported as an error;
+// otherwise the panic from [printer.invalid] is passed through.
+func (pr *Printer) toHTML(b Block, try bool) (html string, err error) {
+	p := printer{Printer: pr}
+	defer p.recoverError(&err, try)
 	p.writeMode = writeHTML
 	b.printHTML(&p)
 	printFootnoteHTML(&p)
+	return p.buf.String(), nil
+}
+
+// HTMLDocumentOptions are options for [ToHTMLDocument]
+// and [Printer.ToHTMLDocument].
+type HTMLDocumentOptions struct {
+	// Title is the document title to use when the document
+	// has no top-level heading, such as the input file name.
+	Title string
+
+	// StyleSheet, if non-empty, is the URL of a CSS style sheet
+	// to link from the document's <head>.
+	StyleSheet string
+}
+
+// ToHTMLDocument returns a complete HTML5 document for doc,
+// using the default [Printer] settings.
+// See [Printer.ToHTMLDocument] for details.
+func ToHTMLDocument(doc *Document, opts *HTMLDocumentOptions) string {
+	return new(Printer).ToHTMLDocument(doc, opts)
+}
+
+// ToHTMLDocument returns a complete HTML5 document for doc,
+// with the HTML for doc, as returned by [Printer.ToHTML], as its <body>.
+// The <head> declares the UTF-8 character set, links the style sheet
+// given by opts, if any, and sets the title to the plain text of
+// the first top-level heading in doc (see [Document.Title]), or else to opts.Title.
+// The opts argument may be nil.
+// Like [Printer.ToHTML], ToHTMLDocument returns an empty string
+// if the HTML for doc would be longer than pr.MaxOutputBytes,
+// rather than a document with an empty or truncated <body>.
+func (pr *Printer) ToHTMLDocument(doc *Document, opts *HTMLDocumentOptions) string {
+	body, err := pr.toHTML(doc, false)
+	if err != nil {
+		return ""
+	}
+	if opts == nil {
+		opts = new(HTMLDocumentOptions)
+	}
+	title := doc.Title()
+	if title == "" {
+		title = opts.Title
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&buf, "<title>%s</title>\n", htmlEscaper.Replace(title))
+	if opts.StyleSheet != "" {
+		fmt.Fprintf(&buf, "<link rel=\"stylesheet\" href=\"%s\">\n", htmlLinkEscaper.Replace(opts.StyleSheet))
+	}
+	buf.WriteString("</head>\n<body>\n")
+	buf.WriteString(body)
+	buf.WriteString("</body>\n</html>\n")
+	return buf.String()
+}
+
+// RenderRange returns the HTML for doc.Blocks[start:end],
+// using the default [Printer] settings.
+// See [Printer.RenderRange].
+func RenderRange(doc *Document, start, end int) string {
+	return new(Printer).RenderRange(doc, start, end)
+}
+
+// RenderRange returns the HTML for doc.Blocks[start:end],
+// followed by the footnotes referred to in those blocks.
+// Link references are resolved during parsing,
+// so links in the range render the same as in the full document,
+// even when the link reference definitions are outside the range.
+// Footnotes are numbered starting at 1 in each rendered range.
+// The blocks print as top-level blocks, as in [Printer.ToHTML],
+// so options such as pr.Sections and pr.BlockHTML apply to them.
+// Like [Printer.ToHTML], RenderRange returns an empty string
+// if the HTML would be longer than pr.MaxOutputBytes.
+// RenderRange panics if start and end are not a valid range for doc.Blocks.
+func (pr *Printer) RenderRange(doc *Document, start, end int) (html string) {
+	p := printer{Printer: pr}
+	var err error // ErrOutputTooLarge, reported as html == ""
+	defer p.recoverError(&err, false)
+	p.writeMode = writeHTML
+	printHTMLBlocks(&p, doc.Blocks[start:end])
+	printFootnoteHTML(&p)
 	return p.buf.String()
 }
 
+// Format returns the Markdown for b, using the default [Printer] settings.
 func Format(b Block) string {
-	var p printer
+	return new(Printer).Format(b)
+}
+
+// FormatBlock returns the Markdown for the single block b,
+// using the default [Printer] settings.
+// See [Printer.FormatBlock].
+func FormatBlock(b Block) string {
+	return new(Printer).FormatBlock(b)
+}
+
+// TryFormat is like [Format] but returns an error
+// instead of panicking if b is not a valid syntax tree.
+// See [Printer.TryFormat].
+func TryFormat(b Block) (string, error) {
+	return new(Printer).TryFormat(b)
+}
+
+// Format returns the Markdown for b.
+// If the Markdown would be longer than pr.MaxOutputBytes,
+// Format returns an empty string; use [Printer.TryFormat]
+// to distinguish that case.
+// Format panics if b is not a valid syntax tree.
+func (pr *Printer) Format(b Block) string {
+	md, _ := pr.format(b, false)
+	return md
+}
+
+// FormatBlock returns the Markdown for b as a fragment,
+// such as a single paragraph or list taken from a larger document,
+// for inclusion in other Markdown text.
+// Unlike [Printer.Format], FormatBlock does not append
+// the definitions of footnotes referenced in b,
+// nor does it end the output with a newline.
+// If b is a [Document], FormatBlock prints its blocks
+// but not its link reference definitions.
+// Like Format, FormatBlock returns an empty string if the Markdown
+// would be longer than pr.MaxOutputBytes,
+// and it panics if b is not a valid syntax tree.
+func (pr *Printer) FormatBlock(b Block) (md string) {
+	p := printer{Printer: pr}
+	var err error // ErrOutputTooLarge, reported as md == ""
+	defer p.recoverError(&err, false)
+	if d, ok := b.(*Document); ok {
+		printMarkdownBlocks(d.Blocks, &p)
+	} else {
+		b.printMarkdown(&p)
+	}
+	return strings.TrimRight(p.buf.String(), "\n")
+}
+
+// TryFormat is like [Printer.Format] but returns
+// an empty string and [ErrOutputTooLarge] if the Markdown
+// would be longer than pr.MaxOutputBytes.
+// If b is not a valid syntax tree, such as a hand-built tree
+// with newlines in inline text where Markdown cannot represent them,
+// TryFormat returns an empty string and an error wrapping [ErrInvalidTree].
+// Other malformed trees, such as ones containing nil blocks,
+// cause runtime panics as in Format.
+func (pr *Printer) TryFormat(b Block) (md string, err error) {
+	return pr.format(b, true)
+}
+
+// format implements [Printer.Format] and [Printer.TryFormat],
+// handling an invalid tree as described for [Printer.toHTML].
+func (pr *Printer) format(b Block, try bool) (md string, err error) {
+	p := printer{Printer: pr}
+	defer p.recoverError(&err, try)
 	b.printMarkdown(&p)
 	printFootnoteMarkdown(&p)
 	// TODO footnotes?
-	return p.buf.String()
+	return p.buf.String(), nil
+}
+
+// A Renderer prints syntax trees using the settings of a [Printer],
+// reusing its output buffer and other internal storage from one call
+// to the next, to avoid most allocation when rendering many small
+// documents, as in a server.
+// The zero Renderer uses the default Printer settings.
+// A Renderer is not safe for concurrent use by multiple goroutines:
+// a server can keep one Renderer per goroutine or keep them in a [sync.Pool].
+type Renderer struct {
+	// Printer holds the settings for rendering.
+	// If Printer is nil, the Renderer uses the default settings.
+	Printer *Printer
+
+	p printer
+}
+
+// defaultPrinter is the Printer used by a Renderer with a nil Printer.
+var defaultPrinter Printer
+
+// RenderHTML writes the HTML for b to w.
+// The HTML is the same as returned by [Printer.ToHTML].
+// RenderHTML returns the errors that [Printer.TryToHTML] would,
+// writing nothing in that case, or else any error writing to w.
+func (r *Renderer) RenderHTML(b Block, w io.Writer) (err error) {
+	p := r.reset(writeHTML)
+	func() {
+		defer p.recoverError(&err, true)
+		b.printHTML(p)
+		printFootnoteHTML(p)
+	}()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(p.buf.Bytes())
+	return err
+}
+
+// RenderMarkdown writes the Markdown for b to w.
+// The Markdown is the same as returned by [Printer.Format].
+// RenderMarkdown returns the errors that [Printer.TryFormat] would,
+// writing nothing in that case, or else any error writing to w.
+func (r *Renderer) RenderMarkdown(b Block, w io.Writer) (err error) {
+	p := r.reset(writeMarkdown)
+	func() {
+		defer p.recoverError(&err, true)
+		b.printMarkdown(p)
+		printFootnoteMarkdown(p)
+	}()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(p.buf.Bytes())
+	return err
+}
+
+// reset resets r's printer for a new rendering in the given mode,
+// keeping the storage of its buffer and footnote tables.
+func (r *Renderer) reset(mode int) *printer {
+	pr := r.Printer
+	if pr == nil {
+		pr = &defaultPrinter
+	}
+	r.p.buf.Reset()
+	clear(r.p.footnotes)
+	clear(r.p.footnotelist)
+	r.p = printer{
+		Printer:      pr,
+		writeMode:    mode,
+		buf:          r.p.buf,
+		footnotes:    r.p.footnotes,
+		footnotelist: r.p.footnotelist[:0],
+	}
+	return &r.p
+}
+
+// ErrOutputTooLarge is the error returned by [Printer.TryToHTML]
+// and [Printer.TryFormat] when the output would be longer
+// than the Printer's MaxOutputBytes.
+var ErrOutputTooLarge = errors.New("markdown: output too large")
+
+// ErrInvalidTree is wrapped by the errors returned by [TryToHTML],
+// [TryFormat], and the corresponding [Printer] methods
+// when the syntax tree being printed violates the invariants
+// that the parser maintains.
+var ErrInvalidTree = errors.New("markdown: invalid syntax tree")
+
+// checkSize panics with [ErrOutputTooLarge] if the output
+// is longer than p.MaxOutputBytes.
+// The panic is recovered by recoverError.
+func (p *printer) checkSize() {
+	if p.Printer != nil && p.MaxOutputBytes > 0 && p.buf.Len() > p.MaxOutputBytes {
+		panic(ErrOutputTooLarge)
+	}
+}
+
+// invalid panics with an error wrapping [ErrInvalidTree].
+// The panic is recovered by recoverError.
+func (p *printer) invalid(msg string) {
+	panic(fmt.Errorf("%w: %s", ErrInvalidTree, msg))
+}
+
+// recoverError recovers a panic from checkSize and,
+// if invalid is set, a panic from [printer.invalid],
+// and sets *errp to the corresponding error.
+// Other panics, including runtime errors, are passed through.
+// It must be called directly by a deferred function.
+func (p *printer) recoverError(errp *error, invalid bool) {
+	e := recover()
+	if e == nil {
+		return
+	}
+	if err, ok := e.(error); ok && (err == ErrOutputTooLarge || invalid && errors.Is(err, ErrInvalidTree)) {
+		*errp = err
+		return
+	}
+	panic(e)
+}
+
+// RoundTrips reports whether the Markdown document src survives formatting:
+// that is, whether parsing src with p, reformatting it with [Format],
+// and parsing the result with p again produces the same HTML as src.
+// If not, RoundTrips also returns the reformatted Markdown,
+// which can help explain the difference.
+func RoundTrips(p *Parser, src string) (ok bool, formatted string) {
+	doc := p.Parse(src)
+	md := Format(doc)
+	if ToHTML(p.Parse(md)) != ToHTML(doc) {
+		return false, md
+	}
+	return true, ""
+}
+
+// class returns a class attribute for the HTML element elem,
+// as configured by p.ClassPrefix, or else an empty string.
+func (p *printer) class(elem string) string {
+	if p.ClassPrefix == "" {
+		return ""
+	}
+	return ` class="` + htmlEscaper.Replace(p.ClassPrefix+"-"+elem) + `"`
+}
+
+// url returns the URL u to use in HTML output,
+// with invalid percents escaped if p.EscapeInvalidPercent is set,
+// resolved against p.BaseURL if u is relative.
+func (p *printer) url(u string) string {
+	if p.EscapeInvalidPercent {
+		u = escapeInvalidPercent(u)
+	}
+	if p.BaseURL == "" || u == "" || u[0] == '#' || p.baseErr {
+		return u
+	}
+	if p.base == nil {
+		base, err := url.Parse(p.BaseURL)
+		if err != nil {
+			p.baseErr = true
+			return u
+		}
+		p.base = base
+	}
+	ref, err := url.Parse(u)
+	if err != nil || ref.IsAbs() {
+		return u
+	}
+	return p.base.ResolveReference(ref).String()
+}
+
+// escapeInvalidPercent returns u with each % that is not
+// followed by two hexadecimal digits replaced by %25.
+func escapeInvalidPercent(u string) string {
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(u); i++ {
+		if u[i] == '%' && (i+2 >= len(u) || !isHexDigit(u[i+1]) || !isHexDigit(u[i+2])) {
+			b.WriteString(u[last : i+1])
+			b.WriteString("25")
+			last = i + 1
+		}
+	}
+	if last == 0 {
+		return u
+	}
+	b.WriteString(u[last:])
+	return b.String()
 }
 
 var closeP = []byte("</p>\n")
@@ -121,62 +959,90 @@ func (b *printer) maybeQuoteNL(quote byte) bool {
 
 func (b *printer) WriteByte(c byte) error {
 	if c == '\n' {
-		panic("Write \\n")
+		b.invalid("WriteByte of newline")
 	}
-	return b.buf.WriteByte(c)
+	err := b.buf.WriteByte(c)
+	b.checkSize()
+	return err
 }
 
 func (p *printer) Write(text []byte) (int, error) {
 	if p.writeMode == writeMarkdown {
 		for i := range text {
 			if text[i] == '\n' {
-				panic("Write \\n")
+				p.invalid("newline in Markdown output")
 			}
 		}
 	}
-	return p.buf.Write(text)
+	n, err := p.buf.Write(text)
+	p.checkSize()
+	return n, err
+}
+
+// indentHTML prints the indentation for a block-level HTML tag
+// at the current depth, if [Printer.HTMLIndent] is set
+// and the output is at the start of a line.
+func (p *printer) indentHTML() {
+	if p.HTMLIndent == "" {
+		return
+	}
+	if n := p.buf.Len(); n > 0 && p.buf.Bytes()[n-1] != '\n' {
+		return
+	}
+	for range p.depth {
+		p.buf.WriteString(p.HTMLIndent)
+	}
 }
 
 func (p *printer) html(list ...string) {
 	if p.writeMode != writeHTML {
-		panic("raw HTML in non-HTML output")
+		p.invalid("raw HTML in non-HTML output")
 	}
 	for _, s := range list {
 		p.buf.WriteString(s)
 	}
+	p.checkSize()
 }
 
 func (p *printer) text(list ...string) {
 	if p.writeMode == writeHTML {
 		for _, s := range list {
+			if p.EscapeNonASCII {
+				writeNonASCII(&p.buf, htmlEscaper.Replace(s))
+				continue
+			}
 			htmlEscaper.WriteString(&p.buf, s)
 		}
+		p.checkSize()
 		return
 	}
 	for _, s := range list {
 		p.buf.WriteString(s)
 	}
-
+	p.checkSize()
 }
 
 func (p *printer) md(list ...string) {
 	if p.writeMode != writeMarkdown {
-		panic("markdown in non-markdown output")
+		p.invalid("Markdown in non-Markdown output")
 	}
 	for _, s := range list {
 		p.buf.WriteString(s)
 	}
+	p.checkSize()
 }
 
 func (b *printer) WriteString(s string) (int, error) {
 	if b.writeMode == writeMarkdown {
 		for i := 0; i < len(s); i++ {
 			if s[i] == '\n' {
-				panic("Write \\n")
+				b.invalid("newline in Markdown output")
 			}
 		}
 	}
-	return b.buf.WriteString(s)
+	n, err := b.buf.WriteString(s)
+	b.checkSize()
+	return n, err
 }
 
 func (b *printer) push(s string) int {
@@ -4,21 +4,39 @@
 
 package markdown
 
+import "strings"
+
 // A Quote is a [Block] representing a [block quote].
 //
 // [block quote]: https://spec.commonmark.org/0.31.2/#block-quotes
 type Quote struct {
 	Position
 	Blocks []Block // content of quote
+
+	// Cite is the URL of the quote's source, if any.
+	// The parser sets Cite when [Parser.QuoteCite] is enabled
+	// and the first line of the quote is a bare http or https URL,
+	// which is then removed from Blocks.
+	// Cite renders as the HTML cite attribute, and
+	// it prints as the first line of the quote in Markdown.
+	Cite string
 }
 
 func (*Quote) Block() {}
 
 func (b *Quote) printHTML(p *printer) {
-	p.html("<blockquote>\n")
+	p.indentHTML()
+	p.html("<blockquote")
+	if b.Cite != "" {
+		p.html(` cite="`, htmlLinkEscaper.Replace(p.url(b.Cite)), `"`)
+	}
+	p.html(p.class("blockquote"), ">\n")
+	p.depth++
 	for _, c := range b.Blocks {
 		c.printHTML(p)
 	}
+	p.depth--
+	p.indentHTML()
 	p.html("</blockquote>\n")
 }
 
@@ -26,7 +44,37 @@ func (b *Quote) printMarkdown(p *printer) {
 	p.maybeQuoteNL('>')
 	p.WriteString("> ")
 	defer p.pop(p.push("> "))
-	printMarkdownBlocks(b.Blocks, p)
+	blocks := b.Blocks
+	if b.Cite != "" {
+		p.md(b.Cite)
+		if len(blocks) == 0 {
+			return
+		}
+		p.nl()
+		switch first := blocks[0].(type) {
+		case *Paragraph:
+			// The paragraph continues the cite line,
+			// and the parser strips the cite line back out.
+			p.sentences = p.SentencePerLine
+			first.Text.printMarkdown(p)
+			p.sentences = false
+			if blocks = blocks[1:]; len(blocks) > 0 {
+				// End the paragraph as printMarkdownBlocks would.
+				p.nl()
+				if p.loose > 0 {
+					p.nl()
+				}
+			}
+		case *Quote:
+			// A nested quote interrupts the cite line's paragraph.
+		default:
+			// Separate the cite line from the first block, which might
+			// otherwise be absorbed into the cite line's paragraph,
+			// as when --- would make the cite line a setext heading.
+			p.nl()
+		}
+	}
+	printMarkdownBlocks(blocks, p)
 }
 
 // A quoteBuildier is a [blockBuilder] for a block quote.
@@ -57,5 +105,60 @@ func (b *quoteBuilder) extend(p *parser, s line) (line, bool) {
 }
 
 func (b *quoteBuilder) build(p *parser) Block {
-	return &Quote{p.pos(), p.blocks()}
+	blocks := p.blocks()
+	var cite string
+	if p.QuoteCite {
+		cite, blocks = trimQuoteCite(p, blocks)
+	}
+	return &Quote{p.pos(), blocks, cite}
+}
+
+// trimQuoteCite implements [Parser.QuoteCite].
+// If blocks begins with a paragraph whose first line is a bare URL,
+// trimQuoteCite removes that line and returns the URL
+// and the remaining blocks.
+// Otherwise it returns "", blocks.
+func trimQuoteCite(p *parser, blocks []Block) (string, []Block) {
+	if len(blocks) == 0 {
+		return "", blocks
+	}
+	para, ok := blocks[0].(*Paragraph)
+	if !ok {
+		return "", blocks
+	}
+	// The paragraph text has not been parsed yet; find its raw form.
+	i := len(p.texts) - 1
+	for i >= 0 && p.texts[i].Text != para.Text {
+		i--
+	}
+	if i < 0 {
+		return "", blocks
+	}
+	first, rest, _ := strings.Cut(p.texts[i].raw, "\n")
+	first = trimSpaceTab(first)
+	if !isCiteURL(first) {
+		return "", blocks
+	}
+	if rest == "" {
+		p.texts = append(p.texts[:i], p.texts[i+1:]...)
+		return first, blocks[1:]
+	}
+	p.texts[i].raw = rest
+	para.StartLine++
+	para.Text.StartLine++
+	return first, blocks
+}
+
+// isCiteURL reports whether s is a bare http or https URL
+// suitable for [Quote.Cite].
+func isCiteURL(s string) bool {
+	if !strings.HasPrefix(s, "https://") && !strings.HasPrefix(s, "http://") {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c <= ' ' || c == '<' || c == '>' || c == 0x7f {
+			return false
+		}
+	}
+	return !strings.HasSuffix(s, "://")
 }
@@ -6,7 +6,10 @@ package markdown
 
 import (
 	"strings"
+	"unicode"
 	"unicode/utf8"
+
+	"golang.org/x/text/width"
 )
 
 // A Table is a [Block] representing a [table], a GitHub-flavored Markdown extension.
@@ -21,12 +24,43 @@ type Table struct {
 
 func (*Table) Block() {}
 
+// CSV returns the content of t as plain text, one slice per row,
+// starting with the header row, for extracting tables as data.
+// Each cell is converted to text as by [Printer.PlainText],
+// with inline markup removed, and each row has the same number
+// of cells as the header, padded with empty strings as needed.
+// The result can be written using [encoding/csv.Writer.WriteAll],
+// or, with the Writer's Comma set to '\t', as tab-separated values.
+func (t *Table) CSV() [][]string {
+	rows := make([][]string, 0, 1+len(t.Rows))
+	for _, row := range append([][]*Text{t.Header}, t.Rows...) {
+		out := make([]string, len(t.Header))
+		for i := range min(len(row), len(out)) {
+			if row[i] != nil {
+				out[i] = plainText(row[i])
+			}
+		}
+		rows = append(rows, out)
+	}
+	return rows
+}
+
 func (t *Table) printHTML(p *printer) {
-	p.html("<table>\n")
+	p.indentHTML()
+	p.html("<table", p.class("table"), ">\n")
+	p.depth++
+	p.indentHTML()
 	p.html("<thead>\n")
+	p.depth++
+	p.indentHTML()
 	p.html("<tr>\n")
+	p.depth++
 	for i, hdr := range t.Header {
+		p.indentHTML()
 		p.html("<th")
+		if p.ARIA {
+			p.html(` scope="col"`)
+		}
 		if t.Align[i] != "" {
 			p.html(` align="`, t.Align[i], `"`)
 		}
@@ -34,13 +68,22 @@ func (t *Table) printHTML(p *printer) {
 		hdr.printHTML(p)
 		p.html("</th>\n")
 	}
+	p.depth--
+	p.indentHTML()
 	p.html("</tr>\n")
+	p.depth--
+	p.indentHTML()
 	p.html("</thead>\n")
 	if len(t.Rows) > 0 {
+		p.indentHTML()
 		p.html("<tbody>\n")
+		p.depth++
 		for _, row := range t.Rows {
+			p.indentHTML()
 			p.html("<tr>\n")
+			p.depth++
 			for i, cell := range row {
+				p.indentHTML()
 				p.html("<td")
 				if i < len(t.Align) && t.Align[i] != "" {
 					p.html(` align="`, t.Align[i], `"`)
@@ -49,10 +92,16 @@ func (t *Table) printHTML(p *printer) {
 				cell.printHTML(p)
 				p.html("</td>\n")
 			}
+			p.depth--
+			p.indentHTML()
 			p.html("</tr>\n")
 		}
+		p.depth--
+		p.indentHTML()
 		p.html("</tbody>\n")
 	}
+	p.depth--
+	p.indentHTML()
 	p.html("</table>\n")
 }
 
@@ -65,7 +114,7 @@ func (t *Table) printMarkdown(p *printer) {
 		rows      = make([][]string, 0, len(t.Rows))
 		maxWidths = make([]int, len(t.Header))
 
-		xb = &printer{}
+		xb = &printer{Printer: p.Printer}
 		xs string
 	)
 
@@ -78,7 +127,7 @@ func (t *Table) printMarkdown(p *printer) {
 	for i, txt := range t.Header {
 		xs = toString(txt)
 		hdr[i] = xs
-		maxWidths[i] = utf8.RuneCountInString(xs)
+		maxWidths[i] = textWidth(xs)
 	}
 
 	for _, row := range t.Rows {
@@ -86,13 +135,20 @@ func (t *Table) printMarkdown(p *printer) {
 		for j := range t.Header {
 			xs = toString(row[j])
 			xrow[j] = xs
-			if n := utf8.RuneCountInString(xs); n > maxWidths[j] {
+			if n := textWidth(xs); n > maxWidths[j] {
 				maxWidths[j] = n
 			}
 		}
 		rows = append(rows, xrow)
 	}
 
+	if p.CompactTables {
+		// No padding in cells; minimal delimiter row.
+		for i := range maxWidths {
+			maxWidths[i] = 0
+		}
+	}
+
 	p.maybeQuoteNL('|')
 	for i, cell := range hdr {
 		p.WriteString("| ")
@@ -104,6 +160,9 @@ func (t *Table) printMarkdown(p *printer) {
 	p.nl()
 	for i, a := range t.Align {
 		w := maxWidths[i]
+		if p.CompactTables {
+			w = 3
+		}
 		p.WriteString("| ")
 		switch a {
 		case "left":
@@ -141,12 +200,96 @@ func repeat(p *printer, c byte, n int) {
 	}
 }
 
+// textWidth returns the display width of text in a fixed-width font,
+// counting each grapheme cluster (see [nextCluster]) as a unit:
+// East Asian wide and fullwidth characters and emoji sequences,
+// like 👍🏽 or the family emoji written with zero-width joiners,
+// are two columns wide, and all other clusters are one.
+func textWidth(text string) int {
+	n := 0
+	for text != "" {
+		i := nextCluster(text)
+		n += clusterWidth(text[:i])
+		text = text[i:]
+	}
+	return n
+}
+
+// clusterWidth returns the display width of the grapheme cluster c.
+func clusterWidth(c string) int {
+	r, size := utf8.DecodeRuneInString(c)
+	if size < len(c) && strings.ContainsFunc(c[size:], isEmojiJoin) {
+		// Emoji sequence, or a character followed by VS16,
+		// which asks for emoji presentation.
+		return 2
+	}
+	if r >= 0x1100 {
+		switch width.LookupRune(r).Kind() {
+		case width.EastAsianWide, width.EastAsianFullwidth:
+			return 2
+		}
+	}
+	return 1
+}
+
+// nextCluster returns the length in bytes of the grapheme cluster
+// (user-perceived character) at the start of s.
+// It approximates the Unicode rules (UAX #29) well enough for
+// measuring text: a cluster is a rune followed by any combining marks,
+// variation selectors, emoji skin tone modifiers, and emoji tags,
+// with runes joined by U+200D ZERO WIDTH JOINER forming a single cluster,
+// as do pairs of regional indicators, which spell flags like 🇯🇵.
+func nextCluster(s string) int {
+	r, i := utf8.DecodeRuneInString(s)
+	if isRegionalIndicator(r) {
+		if r, size := utf8.DecodeRuneInString(s[i:]); isRegionalIndicator(r) {
+			i += size
+		}
+	}
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '\u200d':
+			i += size
+			_, size = utf8.DecodeRuneInString(s[i:])
+			i += size
+		case isGraphemeExtend(r):
+			i += size
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// isGraphemeExtend reports whether r extends the preceding
+// grapheme cluster without a joiner.
+func isGraphemeExtend(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me) ||
+		0xfe00 <= r && r <= 0xfe0f || // variation selectors
+		0x1f3fb <= r && r <= 0x1f3ff || // skin tone modifiers
+		0xe0020 <= r && r <= 0xe007f // tags
+}
+
+// isEmojiJoin reports whether r, appearing after the first rune
+// of a grapheme cluster, makes the cluster an emoji.
+func isEmojiJoin(r rune) bool {
+	return r == '\u200d' || r == '\ufe0f' || 0x1f3fb <= r && r <= 0x1f3ff || isRegionalIndicator(r)
+}
+
+// isRegionalIndicator reports whether r is a regional indicator symbol,
+// pairs of which spell flags.
+func isRegionalIndicator(r rune) bool {
+	return 0x1f1e6 <= r && r <= 0x1f1ff
+}
+
 // pad prints text to p aligned according to align,
-// aiming for a width of w runes.
-// It can happen that multiple runes appear as a single “character”,
-// which will break the alignment, but this is the best we can do for now.
+// aiming for a width of w columns, as measured by textWidth.
+// Characters that a terminal or font draws at some other width,
+// such as emoji sequences the font does not support,
+// will break the alignment, but this is the best we can do.
 func pad(p *printer, text, align string, w int) {
-	n := w - utf8.RuneCountInString(text)
+	n := w - textWidth(text)
 	switch align {
 	default:
 		p.WriteString(text)
@@ -230,6 +373,13 @@ func isTableStart(hdr1, delim1 string) bool {
 		}
 		if i < len(delim) && delim[i] == '|' {
 			i++
+			for i < len(delim) && isTableSpace(delim[i]) {
+				i++
+			}
+			if i >= len(delim) {
+				// Empty final cell, as in "| - ||".
+				return false
+			}
 		}
 	}
 
@@ -241,6 +391,11 @@ func isTableStart(hdr1, delim1 string) bool {
 		return false
 	}
 
+	// “The header row must match the delimiter row in the number of cells.
+	// If not, a table will not be recognized.”
+	// GitHub (cmark-gfm) enforces this in both directions,
+	// so a delimiter row with extra columns is not a table either.
+	// See https://github.github.com/gfm/#example-203.
 	return col == tableCount(tableTrimOuter(hdr1))
 }
 
@@ -343,23 +498,37 @@ func tableUnescape(text string) string {
 	return string(out)
 }
 
-// parseAlign TODO
+// parseAlign returns the alignments of the n columns
+// described by the delimiter row delim.
+// [isTableStart] has already checked that delim has n cells,
+// but parseAlign stops at n and pads with "" anyway,
+// so that Align always matches Header.
 func (b *tableBuilder) parseAlign(delim tableTrimmed, n int) []string {
-	align := make([]string, 0, tableCount(delim))
+	align := make([]string, 0, n)
 	start := 0
-	for i := 0; i < len(delim); i++ {
+	for i := 0; i < len(delim) && len(align) < n; i++ {
 		if delim[i] == '|' {
 			align = append(align, tableAlign(string(delim[start:i])))
 			start = i + 1
 		}
 	}
-	align = append(align, tableAlign(string(delim[start:])))
+	if len(align) < n {
+		align = append(align, tableAlign(string(delim[start:])))
+	}
+	for len(align) < n {
+		align = append(align, "")
+	}
 	return align
 }
 
-// tableAlign TODO
+// tableAlign returns the alignment of a column
+// given its delimiter cell: "left" for :--, "right" for --:,
+// "center" for :-:, and "" for --- or an empty cell.
 func tableAlign(cell string) string {
 	cell = tableTrimSpace(cell)
+	if cell == "" {
+		return ""
+	}
 	l := cell[0] == ':'
 	r := cell[len(cell)-1] == ':'
 	switch {
@@ -5,6 +5,7 @@
 package markdown
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -41,6 +42,31 @@ func TestTableCount(t *testing.T) {
 	}
 }
 
+func TestTextWidth(t *testing.T) {
+	tests := []struct {
+		text string
+		w    int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"日本", 4},
+		{"e\u0301", 1},                        // e + combining acute accent
+		{"\U0001F600", 2},                     // grinning face
+		{"\U0001F44D\U0001F3FD", 2},           // thumbs up with skin tone
+		{"\u2764\ufe0f", 2},                   // heart with emoji presentation
+		{"\u2764", 1},                         // text-presentation heart
+		{"\U0001F1EF\U0001F1F5", 2},           // flag: JP
+		{"\U0001F1EF\U0001F1F5\U0001F1FA", 3}, // flag and a lone regional indicator
+		{"\U0001F468\u200d\U0001F469\u200d\U0001F467\u200d\U0001F466", 2}, // family
+		{"a\U0001F468\u200d\U0001F469\u200d\U0001F467\u200d\U0001F466b", 4},
+	}
+	for _, tt := range tests {
+		if w := textWidth(tt.text); w != tt.w {
+			t.Errorf("textWidth(%+q) = %d, want %d", tt.text, w, tt.w)
+		}
+	}
+}
+
 func TestPad(t *testing.T) {
 	testCases := []struct {
 		raw, align string
@@ -52,8 +78,13 @@ func TestPad(t *testing.T) {
 		{"foo", "center", 6, " foo  "},
 		{"foo", "center", 5, " foo "},
 		{"föó", "center", 5, " föó "},
+		{"日本", "center", 6, " 日本 "},
+		{"日本", "left", 6, "日本  "},
+		{"ｆｏｏ", "right", 8, "  ｆｏｏ"},
 		{"foo", "center", 4, "foo "},
 		{"foo", "center", 3, "foo"},
+		{"\U0001F468\u200d\U0001F469\u200d\U0001F467\u200d\U0001F466", "center", 6, "  \U0001F468\u200d\U0001F469\u200d\U0001F467\u200d\U0001F466  "},
+		{"\U0001F44D\U0001F3FD!", "left", 5, "\U0001F44D\U0001F3FD!  "},
 
 		{"foo", "left", 8, "foo     "},
 		{"foo", "right", 8, "     foo"},
@@ -89,3 +120,17 @@ func TestPad(t *testing.T) {
 		}
 	}
 }
+
+func TestTableCSV(t *testing.T) {
+	p := Parser{Table: true}
+	doc := p.Parse("| Name | `Type` | Notes |\n| --- | :-: | --- |\n| *a* | int | see [docs](/d) |\n| b \\| c | string |\n")
+	have := doc.Blocks[0].(*Table).CSV()
+	want := [][]string{
+		{"Name", "Type", "Notes"},
+		{"a", "int", "see docs"},
+		{"b | c", "string", ""},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("CSV() = %q, want %q", have, want)
+	}
+}
@@ -17,7 +17,7 @@ import (
 
 var parsers = map[string]string{
 	"example autolink":      `{"AutoLinkText": true, "AutoLinkAssumeHTTP": true}`,
-	"example disabled":      `{"TaskListItems": true}`,
+	"example disabled":      `{"TaskList": true}`,
 	"example strikethrough": `{"Strikethrough": true}`,
 	"example table":         `{"Table": true}`,
 }
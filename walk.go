@@ -0,0 +1,94 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package markdown
+
+// Walk traverses the syntax tree rooted at b in depth-first order.
+// It calls block for b and, if block returns true,
+// walks the blocks that b contains: the blocks of a [Document], [Quote],
+// [Item], or [Details], the items of a [List], the summary of a Details,
+// the text of a [Paragraph] or [Heading], and the cells of a [Table].
+// For each [Text], Walk walks its inlines as described for inline.
+// Either function can be nil: a nil block walks every block,
+// and a nil inline skips the inlines entirely.
+//
+// Walk does not follow [FootnoteLink]s or walk [Document.Notes];
+// callers that need the blocks of footnotes can walk them separately.
+func Walk(b Block, block func(Block) bool, inline func(Inline) bool) {
+	if block != nil && !block(b) {
+		return
+	}
+	switch b := b.(type) {
+	case *Document:
+		walkBlocks(b.Blocks, block, inline)
+	case *Quote:
+		walkBlocks(b.Blocks, block, inline)
+	case *List:
+		walkBlocks(b.Items, block, inline)
+	case *Item:
+		walkBlocks(b.Blocks, block, inline)
+	case *Details:
+		if b.Summary != nil {
+			Walk(b.Summary, block, inline)
+		}
+		walkBlocks(b.Blocks, block, inline)
+	case *Paragraph:
+		if b.Text != nil {
+			Walk(b.Text, block, inline)
+		}
+	case *Heading:
+		if b.Text != nil {
+			Walk(b.Text, block, inline)
+		}
+	case *Table:
+		for _, t := range b.Header {
+			Walk(t, block, inline)
+		}
+		for _, row := range b.Rows {
+			for _, t := range row {
+				Walk(t, block, inline)
+			}
+		}
+	case *Text:
+		if b != nil && inline != nil {
+			for _, x := range b.Inline {
+				walkInline(x, inline)
+			}
+		}
+	}
+}
+
+// walkBlocks calls [Walk] for each block in list.
+func walkBlocks(list []Block, block func(Block) bool, inline func(Inline) bool) {
+	for _, b := range list {
+		Walk(b, block, inline)
+	}
+}
+
+// walkInline calls inline for x and, if inline returns true,
+// walks the inlines that x contains: the elements of an [Inlines]
+// and the inner text of an [Emph], [Strong], [Del], [Link], or [Image].
+func walkInline(x Inline, inline func(Inline) bool) {
+	if !inline(x) {
+		return
+	}
+	var inner Inlines
+	switch x := x.(type) {
+	case Inlines:
+		inner = x
+	case *Emph:
+		inner = x.Inner
+	case *Strong:
+		inner = x.Inner
+	case *Del:
+		inner = x.Inner
+	case *Link:
+		inner = x.Inner
+	case *Image:
+		inner = x.Inner
+	}
+	for _, y := range inner {
+		walkInline(y, inline)
+	}
+}